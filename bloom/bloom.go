@@ -0,0 +1,136 @@
+// Package bloom implements a blocked Bloom filter over 64-bit key hashes:
+// every key sets its bits within a single block of BLOCK_BITS bits, so a
+// lookup touches one cache line instead of k random ones, at the price of a
+// slightly higher false positive rate than a plain Bloom filter.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	BLOCK_BITS  = 512
+	BLOCK_WORDS = BLOCK_BITS / 64
+	MAX_HASHES  = 16
+	MAGIC       = "IKVBLM01"
+)
+
+var ErrCorrupt = errors.New("bloom: corrupt filter")
+
+// Filter answers whether a hash may have been added. It never misses an added
+// hash, and reports a hash that was not added with a probability depending on
+// the bits per key it was sized with, about 1% for 10 bits per key.
+type Filter struct {
+	k     uint32
+	words []uint64
+}
+
+// New returns a filter sized for n hashes with bitsPerKey bits each.
+func New(n int, bitsPerKey float64) *Filter {
+	blocks := blocks(n, bitsPerKey)
+	k := uint32(math.Round(bitsPerKey * math.Ln2))
+	if k < 1 {
+		k = 1
+	} else if k > MAX_HASHES {
+		k = MAX_HASHES
+	}
+	return &Filter{k: k, words: make([]uint64, blocks*BLOCK_WORDS)}
+}
+
+// Size returns the size in bits of New(n, bitsPerKey), which rounds the bits
+// of the keys up to whole blocks.
+func Size(n int, bitsPerKey float64) int {
+	return blocks(n, bitsPerKey) * BLOCK_BITS
+}
+
+func blocks(n int, bitsPerKey float64) int {
+	blocks := int(math.Ceil(float64(n) * bitsPerKey / BLOCK_BITS))
+	if blocks < 1 {
+		blocks = 1
+	}
+	return blocks
+}
+
+// mix is murmur3's finalizer. The key hashes of a chunk share their residue
+// modulo the chunk count, so they are remixed before picking bits.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// probe returns the block of hash and the start and step of its bits within
+// the block.
+func (f *Filter) probe(hash uint64) (block []uint64, start, step uint32) {
+	h := mix(hash)
+	n, _ := bits.Mul64(h, uint64(len(f.words)/BLOCK_WORDS))
+	block = f.words[n*BLOCK_WORDS : (n+1)*BLOCK_WORDS]
+	h = mix(h ^ 0x9e3779b97f4a7c15)
+	return block, uint32(h), uint32(h>>32) | 1
+}
+
+func (f *Filter) Add(hash uint64) {
+	block, bit, step := f.probe(hash)
+	for i := uint32(0); i < f.k; i++ {
+		b := bit % BLOCK_BITS
+		block[b/64] |= 1 << (b % 64)
+		bit += step
+	}
+}
+
+// MayContain reports false only when hash was never added.
+func (f *Filter) MayContain(hash uint64) bool {
+	block, bit, step := f.probe(hash)
+	for i := uint32(0); i < f.k; i++ {
+		b := bit % BLOCK_BITS
+		if block[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+		bit += step
+	}
+	return true
+}
+
+// Bits returns the size of the filter in bits.
+func (f *Filter) Bits() int {
+	return 64 * len(f.words)
+}
+
+// MarshalBinary encodes the filter as MAGIC, k, the word count and the words.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, len(MAGIC)+16+8*len(f.words))
+	copy(buf, MAGIC)
+	data := buf[len(MAGIC):]
+	binary.LittleEndian.PutUint64(data, uint64(f.k))
+	binary.LittleEndian.PutUint64(data[8:], uint64(len(f.words)))
+	for i, word := range f.words {
+		binary.LittleEndian.PutUint64(data[16+8*i:], word)
+	}
+	return buf, nil
+}
+
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < len(MAGIC)+16 || string(data[:len(MAGIC)]) != MAGIC {
+		return fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	data = data[len(MAGIC):]
+	k := binary.LittleEndian.Uint64(data)
+	words := binary.LittleEndian.Uint64(data[8:])
+	data = data[16:]
+	if k < 1 || k > MAX_HASHES || words == 0 || words%BLOCK_WORDS != 0 || uint64(len(data)) != 8*words {
+		return fmt.Errorf("%w: %v hashes, %v words in %v bytes", ErrCorrupt, k, words, len(data))
+	}
+	f.k = uint32(k)
+	f.words = make([]uint64, words)
+	for i := range f.words {
+		f.words[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	return nil
+}
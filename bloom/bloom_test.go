@@ -0,0 +1,85 @@
+package bloom
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	n := 100000
+	f := New(n, 10)
+	added := make(map[uint64]struct{}, n)
+	for len(added) < n {
+		// hashes of one chunk share their residue, as in the index
+		hash := rand.Uint64()/1000*1000 + 7
+		added[hash] = struct{}{}
+		f.Add(hash)
+	}
+	for hash := range added {
+		if !f.MayContain(hash) {
+			log.Fatalf("added hash %v is missing", hash)
+		}
+	}
+	positives := 0
+	for i := 0; i < n; i++ {
+		hash := rand.Uint64()/1000*1000 + 7
+		if _, exist := added[hash]; !exist && f.MayContain(hash) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / float64(n); rate > 0.02 {
+		log.Fatalf("false positive rate: %v with 10 bits per key", rate)
+	}
+
+	data, _ := f.MarshalBinary()
+	loaded := new(Filter)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		log.Fatalf("unmarshal filter err: %v", err)
+	}
+	for hash := range added {
+		if !loaded.MayContain(hash) {
+			log.Fatalf("added hash %v is missing from the loaded filter", hash)
+		}
+	}
+	if err := loaded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrCorrupt) {
+		log.Fatalf("unmarshal truncated filter err: %v", err)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	f := New(0, 10)
+	if f.Bits() != BLOCK_BITS {
+		log.Fatalf("empty filter bits: %v, want: %v", f.Bits(), BLOCK_BITS)
+	}
+	for i := uint64(0); i < 100; i++ {
+		if f.MayContain(i) {
+			log.Fatalf("empty filter may contain %v", i)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	for _, n := range []int{0, 1, 31, 32, 33, 100000} {
+		for _, bitsPerKey := range []float64{1, 10, 16} {
+			if size, bits := Size(n, bitsPerKey), New(n, bitsPerKey).Bits(); size != bits {
+				log.Fatalf("size of %v keys of %v bits: %v, want: %v", n, bitsPerKey, size, bits)
+			}
+		}
+	}
+}
+
+func BenchmarkMayContain(b *testing.B) {
+	n := 1000000
+	f := New(n, 10)
+	hashes := make([]uint64, n)
+	for i := range hashes {
+		hashes[i] = rand.Uint64()
+		f.Add(hashes[i])
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.MayContain(hashes[i%n] + uint64(i&1))
+	}
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ARC is an adaptive replacement cache. Values accessed once live in t1 and
+// values accessed again in t2, and the ghosts of the values evicted from each
+// live in b1 and b2. A value added again after its eviction from t1 grows the
+// target size of t1, after its eviction from t2 shrinks it, so the cache
+// balances recency and frequency by itself.
+type ARC struct {
+	mutex sync.Mutex
+	lists
+	capacity      int64
+	ghostCapacity int64
+	// p is the target size of t1
+	p  int64
+	t1 segment
+	t2 segment
+	b1 segment
+	b2 segment
+}
+
+// NewARC returns an ARC cache holding at most capacity bytes, including the
+// ghosts.
+func NewARC(capacity int64) (*ARC, error) {
+	ghosts := capacity * GHOST_PERCENT / 100
+	if capacity-ghosts <= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrCapacity, capacity)
+	}
+	values := capacity - ghosts
+	return &ARC{
+		lists:         newLists(),
+		capacity:      values,
+		ghostCapacity: ghosts,
+		t1:            newSegment(values),
+		t2:            newSegment(values),
+		b1:            newSegment(values),
+		b2:            newSegment(values),
+	}, nil
+}
+
+func (c *ARC) Add(key []byte, value []byte) {
+	e := newEntry(key, value)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exist := c.items[e.key]
+	if !exist {
+		if e.size > c.capacity {
+			return
+		}
+		c.stats.Adds++
+		c.replace(e.size, false)
+		c.insert(e, &c.t1)
+		c.trimGhosts()
+		return
+	}
+
+	old := c.remove(elem)
+	if e.size > c.capacity {
+		return
+	}
+	c.stats.Adds++
+	switch old.segment {
+	case &c.b1:
+		delta := old.size
+		if c.b2.size > c.b1.size+old.size {
+			delta = old.size * c.b2.size / (c.b1.size + old.size)
+		}
+		c.p = min64(c.p+delta, c.capacity)
+	case &c.b2:
+		delta := old.size
+		if c.b1.size > c.b2.size+old.size {
+			delta = old.size * c.b1.size / (c.b2.size + old.size)
+		}
+		c.p = max64(c.p-delta, 0)
+	}
+	c.replace(e.size, old.segment == &c.b2)
+	c.insert(e, &c.t2)
+	c.trimGhosts()
+}
+
+// replace evicts values to ghosts until size bytes fit, from t1 while it is
+// larger than its target and from t2 otherwise.
+func (c *ARC) replace(size int64, inB2 bool) {
+	for c.t1.size+c.t2.size+size > c.capacity {
+		if c.t1.size > 0 && (c.t1.size > c.p || (inB2 && c.t1.size == c.p) || c.t2.size == 0) {
+			c.ghost(c.t1.list.Back(), &c.b1)
+		} else {
+			c.ghost(c.t2.list.Back(), &c.b2)
+		}
+	}
+}
+
+// trimGhosts bounds the ghosts as ARC does, t1 and b1 within the capacity and
+// all lists within twice the capacity, and their memory within ghostCapacity.
+func (c *ARC) trimGhosts() {
+	for c.b1.size > 0 && c.t1.size+c.b1.size > c.capacity {
+		c.remove(c.b1.list.Back())
+	}
+	for c.b2.size > 0 && c.t1.size+c.t2.size+c.b1.size+c.b2.size > 2*c.capacity {
+		c.remove(c.b2.list.Back())
+	}
+	for c.ghostBytes > c.ghostCapacity {
+		if c.b1.size >= c.b2.size {
+			c.remove(c.b1.list.Back())
+		} else {
+			c.remove(c.b2.list.Back())
+		}
+	}
+}
+
+func (c *ARC) Get(key []byte) (value []byte, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exist := c.items[string(key)]
+	if !exist || elem.Value.(*entry).ghost {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	if elem.Value.(*entry).segment == &c.t2 {
+		c.t2.list.MoveToFront(elem)
+	} else {
+		c.move(elem, &c.t2)
+	}
+	return elem.Value.(*entry).value, true
+}
+
+func (c *ARC) Remove(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[string(key)]; exist {
+		c.remove(elem)
+	}
+}
+
+func (c *ARC) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.t1.list.Len() + c.t2.list.Len()
+}
+
+func (c *ARC) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.snapshot(&c.t1, &c.t2)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
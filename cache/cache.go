@@ -1,39 +1,159 @@
+// Package cache implements caches of values by key bounded by bytes, with
+// several eviction policies behind the Cache interface.
 package cache
 
 import (
-	lru "github.com/hashicorp/golang-lru"
-	"log"
+	"container/list"
+	"errors"
 )
 
-type Cache struct {
-	cache *lru.Cache
+// ENTRY_OVERHEAD approximates the bytes an entry takes besides its key and
+// value: the list element, the map slot and the string and slice headers.
+const ENTRY_OVERHEAD = 128
+
+var ErrCapacity = errors.New("cache: capacity must be positive")
+
+// Cache caches values by key within a capacity in bytes, counting the keys,
+// the values and ENTRY_OVERHEAD per entry. Values are shared with the cache
+// and must not be modified once added or returned. Caches are safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the value cached under key.
+	Get(key []byte) (value []byte, success bool)
+	// Add caches value under key, evicting values to make room. A value too
+	// large for the whole cache is not cached.
+	Add(key []byte, value []byte)
+	// Remove drops key and anything the cache remembers about it.
+	Remove(key []byte)
+	// Len returns the number of cached values.
+	Len() int
+	Stats() Stats
 }
 
-func New(cacheSize int) (*Cache, error) {
-	var (
-		err error = nil
-		c         = &Cache{}
-	)
-	c.cache, err = lru.New(cacheSize)
-	if err != nil {
-		log.Fatalf("[cache.cache.New] create LRU cache fail: %v", err)
-	}
-	return c, nil
+// Stats counts the activity of a cache since it was created.
+type Stats struct {
+	Hits   int64
+	Misses int64
+	// Adds counts the values cached by Add, and Evictions the values dropped
+	// to make room for others.
+	Adds      int64
+	Evictions int64
+	Entries   int
+	// Bytes is the size of the cached entries and of the ghosts of the
+	// policies keeping them, as counted against capacity.
+	Bytes int64
+}
+
+// entry is a cached value, or a ghost remembering the key of an evicted value
+// for the policies that adapt to misses on recently evicted keys.
+type entry struct {
+	key   string
+	value []byte
+	// hash is the key hash of TinyLFU
+	hash uint64
+	// size is the size of the value entry, kept by its ghost
+	size    int64
+	ghost   bool
+	segment *segment
+	// referenced is the bit of Clock, set atomically by Get
+	referenced uint32
+}
+
+func entrySize(key string, value []byte) int64 {
+	return int64(len(key)+len(value)) + ENTRY_OVERHEAD
+}
+
+// ghostSize is the memory taken by the ghost of key.
+func ghostSize(key string) int64 {
+	return int64(len(key)) + ENTRY_OVERHEAD
+}
+
+func newEntry(key []byte, value []byte) *entry {
+	e := &entry{key: string(key), value: value}
+	e.size = entrySize(e.key, value)
+	return e
+}
+
+// segment is an LRU list of entries, from the most to the least recently
+// used, of size bytes.
+type segment struct {
+	list     *list.List
+	size     int64
+	capacity int64
+}
+
+func newSegment(capacity int64) segment {
+	return segment{list: list.New(), capacity: capacity}
 }
 
-func (c *Cache) Add(key string, value string) {
-	log.Printf("[cache.cache.Add] add key: %s, value: %s", key, value)
-	c.cache.Add(key, value)
+func (s *segment) pushFront(e *entry) *list.Element {
+	e.segment = s
+	s.size += e.size
+	return s.list.PushFront(e)
 }
 
-func (c *Cache) Get(key string) (value string, success bool) {
-	vInterface, ok := c.cache.Get(key)
-	if !ok || vInterface == nil {
-		return "", false
+func (s *segment) remove(elem *list.Element) *entry {
+	e := s.list.Remove(elem).(*entry)
+	s.size -= e.size
+	return e
+}
+
+// lists holds the entries of a policy in segments, indexed by key.
+type lists struct {
+	items map[string]*list.Element
+	stats Stats
+	// ghostBytes is the memory taken by the ghosts
+	ghostBytes int64
+}
+
+func newLists() lists {
+	return lists{items: make(map[string]*list.Element)}
+}
+
+// move moves elem to the front of to.
+func (l *lists) move(elem *list.Element, to *segment) *entry {
+	e := elem.Value.(*entry)
+	e.segment.remove(elem)
+	l.items[e.key] = to.pushFront(e)
+	return e
+}
+
+func (l *lists) insert(e *entry, to *segment) {
+	l.items[e.key] = to.pushFront(e)
+}
+
+func (l *lists) remove(elem *list.Element) *entry {
+	e := elem.Value.(*entry)
+	e.segment.remove(elem)
+	delete(l.items, e.key)
+	if e.ghost {
+		l.ghostBytes -= ghostSize(e.key)
 	}
-	value, ok = vInterface.(string)
-	if !ok {
-		return "", false
+	return e
+}
+
+// evict removes the least recently used entry of s, which must not be empty.
+func (l *lists) evict(s *segment) *entry {
+	l.stats.Evictions++
+	return l.remove(s.list.Back())
+}
+
+// ghost turns the entry of elem into a ghost at the front of to.
+func (l *lists) ghost(elem *list.Element, to *segment) {
+	e := l.remove(elem)
+	l.stats.Evictions++
+	l.insert(&entry{key: e.key, size: e.size, ghost: true}, to)
+	l.ghostBytes += ghostSize(e.key)
+}
+
+// snapshot returns the stats with the entries of the value segments and the
+// memory of the ghosts.
+func (l *lists) snapshot(values ...*segment) Stats {
+	stats := l.stats
+	stats.Bytes = l.ghostBytes
+	for _, s := range values {
+		stats.Entries += s.list.Len()
+		stats.Bytes += s.size
 	}
-	return value, true
+	return stats
 }
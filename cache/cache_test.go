@@ -1,42 +1,99 @@
 package cache
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"os"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestCache(t *testing.T) {
-	c, err := New(2)
+	c, err := NewLRU(2 * (ENTRY_OVERHEAD + 8))
 	if err != nil {
 		panic("fail to create c")
 	}
-	c.Add("1111", "1111")
-	v, success := c.Get("1111")
+	c.Add([]byte("1111"), []byte("1111"))
+	v, success := c.Get([]byte("1111"))
 	if !success {
 		panic("failed get key 1111")
 	}
-	if v != "1111" {
+	if string(v) != "1111" {
 		log.Fatalln("get wrong value for key 1111")
 	}
 
-	c.Add("2222", "2222")
-	c.Add("333", "")
+	c.Add([]byte("2222"), []byte("2222"))
+	c.Add([]byte("333"), []byte(""))
 
-	v, success = c.Get("2222")
+	v, success = c.Get([]byte("2222"))
 	if !success {
 		panic("failed get key 1111")
 	}
-	if v != "2222" {
+	if string(v) != "2222" {
 		log.Fatalln("get wrong value for key 2222")
 	}
 
-	v, success = c.Get("1111")
+	v, success = c.Get([]byte("1111"))
 	if success {
 		log.Fatalln("LRU c error: key 1111 should be deleted from c")
 	}
+	if _, err = NewLRU(0); !errors.Is(err, ErrCapacity) {
+		log.Fatalf("zero capacity err: %v\n", err)
+	}
+}
+
+func TestCache_Size(t *testing.T) {
+	capacity := int64(4 << 10)
+	c, err := NewLRU(capacity)
+	if err != nil {
+		log.Fatalf("create cache err: %v\n", err)
+	}
+	small := bytes.Repeat([]byte("s"), 100)
+	for i := 0; i < 10; i++ {
+		c.Add([]byte(strconv.Itoa(i)), small)
+	}
+	if c.Len() != 10 || c.Stats().Bytes != 10*(1+100+ENTRY_OVERHEAD) {
+		log.Fatalf("cache of %v entries, %v bytes\n", c.Len(), c.Stats().Bytes)
+	}
+
+	// a large value evicts the least recently used entries to make room
+	c.Get([]byte("0"))
+	large := bytes.Repeat([]byte("l"), 3<<10)
+	c.Add([]byte("large"), large)
+	if c.Stats().Bytes > capacity {
+		log.Fatalf("cache of %v bytes over its capacity %v\n", c.Stats().Bytes, capacity)
+	}
+	if _, ok := c.Get([]byte("0")); !ok {
+		log.Fatalln("recently used key 0 was evicted")
+	}
+	if _, ok := c.Get([]byte("1")); ok {
+		log.Fatalln("least recently used key 1 was kept")
+	}
+	if v, ok := c.Get([]byte("large")); !ok || !bytes.Equal(v, large) {
+		log.Fatalln("large value is missing")
+	}
+
+	// replacing a value accounts for its new size
+	size := c.Stats().Bytes
+	c.Add([]byte("0"), small[:10])
+	if c.Stats().Bytes != size-90 {
+		log.Fatalf("cache of %v bytes after shrinking a value, want: %v\n", c.Stats().Bytes, size-90)
+	}
+
+	// a value larger than the whole cache is not cached, nor kept stale
+	c.Add([]byte("0"), bytes.Repeat([]byte("h"), int(capacity)))
+	if _, ok := c.Get([]byte("0")); ok {
+		log.Fatalln("value larger than the cache was cached")
+	}
+	if c.Stats().Bytes != size-(1+100+ENTRY_OVERHEAD) {
+		log.Fatalf("cache of %v bytes after rejecting a value\n", c.Stats().Bytes)
+	}
 }
 
 func TestCache_Coverage(t *testing.T) {
@@ -44,24 +101,25 @@ func TestCache_Coverage(t *testing.T) {
 		TestCount = 1e5
 	)
 	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
-	c, err := New(100)
+	entrySize := int64(ENTRY_OVERHEAD + 2*len(strconv.Itoa(150)))
+	c, err := NewLRU(100 * entrySize)
 	if err != nil {
 		log.Fatalln("fail to create c")
 	}
 
-	for i := 0; i < 300; i++ {
-		c.Add(strconv.Itoa(i), strconv.Itoa(i))
+	for i := 100; i < 400; i++ {
+		c.Add([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
 	}
-	if c.cache.Len() != 100 {
-		log.Fatalf("incorrect cache size, expected: 50, actual: %v\n", c.cache.Len())
+	if c.Len() != 100 {
+		log.Fatalf("incorrect cache size, expected: 100, actual: %v\n", c.Len())
 	}
 	var hitCount, totalCount float64
 	for i := 0; i < TestCount; i++ {
 		totalCount++
-		key := seededRand.Intn(TestCount) % 300
-		value, ok := c.Get(strconv.Itoa(key))
+		key := 100 + seededRand.Intn(TestCount)%300
+		value, ok := c.Get([]byte(strconv.Itoa(key)))
 		log.Printf("lookup key: %d, value: %s", key, value)
-		if ok && strconv.Itoa(key) == value {
+		if ok && strconv.Itoa(key) == string(value) {
 			hitCount++
 			log.Printf("hit: %d", key)
 			continue
@@ -70,3 +128,295 @@ func TestCache_Coverage(t *testing.T) {
 	}
 	log.Printf("hit rate: %.2f", hitCount/totalCount)
 }
+
+func TestTinyLFU(t *testing.T) {
+	if _, err := NewTinyLFU(16); !errors.Is(err, ErrCapacity) {
+		log.Fatalf("capacity below the sketch err: %v\n", err)
+	}
+	capacity := int64(64 << 10)
+	c, err := NewTinyLFU(capacity)
+	if err != nil {
+		log.Fatalf("create cache err: %v\n", err)
+	}
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 3000; i++ {
+		key := []byte(strconv.Itoa(i % 300))
+		if v, ok := c.Get(key); ok {
+			if !bytes.Equal(v, value) {
+				log.Fatalf("get wrong value for key %s\n", key)
+			}
+			continue
+		}
+		c.Add(key, value)
+		if c.Stats().Bytes > capacity {
+			log.Fatalf("cache of %v bytes over its capacity %v\n", c.Stats().Bytes, capacity)
+		}
+	}
+	if c.Len() == 0 {
+		log.Fatalln("nothing was admitted into an empty cache")
+	}
+
+	// a scan of one-off keys does not evict the keys accessed repeatedly
+	hot := c.Len()
+	for i := 0; i < 1000; i++ {
+		key := []byte("scan" + strconv.Itoa(i))
+		c.Get(key)
+		c.Add(key, value)
+	}
+	kept := 0
+	for i := 0; i < 300; i++ {
+		if _, ok := c.Get([]byte(strconv.Itoa(i))); ok {
+			kept++
+		}
+	}
+	if kept < hot*9/10 {
+		log.Fatalf("%v of %v hot keys kept after a scan\n", kept, hot)
+	}
+
+	// replacing a value keeps a single entry
+	c.Add([]byte("0"), value[:10])
+	n := c.Len()
+	c.Add([]byte("0"), value[:20])
+	if v, ok := c.Get([]byte("0")); !ok || len(v) != 20 || c.Len() != n {
+		log.Fatalf("replaced value of %v bytes, %v entries, want %v\n", len(v), c.Len(), n)
+	}
+}
+
+var policies = []struct {
+	name string
+	new  func(capacity int64) (Cache, error)
+}{
+	{"lru", func(capacity int64) (Cache, error) { return NewLRU(capacity) }},
+	{"tinylfu", func(capacity int64) (Cache, error) { return NewTinyLFU(capacity) }},
+	{"2q", func(capacity int64) (Cache, error) { return New2Q(capacity) }},
+	{"arc", func(capacity int64) (Cache, error) { return NewARC(capacity) }},
+	{"clock", func(capacity int64) (Cache, error) { return NewClock(capacity) }},
+	{"lru-sharded", func(capacity int64) (Cache, error) {
+		return NewSharded(capacity, 4, func(capacity int64) (Cache, error) { return NewLRU(capacity) })
+	}},
+	{"clock-sharded", func(capacity int64) (Cache, error) {
+		return NewSharded(capacity, 4, func(capacity int64) (Cache, error) { return NewClock(capacity) })
+	}},
+}
+
+func TestPolicies(t *testing.T) {
+	capacity := int64(64 << 10)
+	seededRand := rand.New(rand.NewSource(1))
+	for _, p := range policies {
+		if _, err := p.new(0); !errors.Is(err, ErrCapacity) {
+			log.Fatalf("%v: zero capacity err: %v\n", p.name, err)
+		}
+		c, err := p.new(capacity)
+		if err != nil {
+			log.Fatalf("%v: create cache err: %v\n", p.name, err)
+		}
+		var gets, adds int64
+		for i := 0; i < 20000; i++ {
+			key := []byte(strconv.Itoa(seededRand.Intn(1000)))
+			gets++
+			if v, ok := c.Get(key); ok {
+				if !bytes.Equal(v, bytes.Repeat(key, len(v)/len(key))) {
+					log.Fatalf("%v: get wrong value %q for key %s\n", p.name, v, key)
+				}
+				continue
+			}
+			// values of various sizes, up to a few percent of the capacity
+			adds++
+			c.Add(key, bytes.Repeat(key, seededRand.Intn(500)))
+			if stats := c.Stats(); stats.Bytes > capacity || stats.Entries != c.Len() {
+				log.Fatalf("%v: %v entries of %v bytes over its capacity %v, len %v\n",
+					p.name, stats.Entries, stats.Bytes, capacity, c.Len())
+			}
+		}
+		stats := c.Stats()
+		if stats.Hits+stats.Misses != gets || stats.Hits == 0 || stats.Adds != adds ||
+			stats.Adds-stats.Evictions != int64(stats.Entries) {
+			log.Fatalf("%v: stats %+v after %v gets and %v adds\n", p.name, stats, gets, adds)
+		}
+
+		c.Add([]byte("key"), []byte("value"))
+		c.Get([]byte("key"))
+		c.Remove([]byte("key"))
+		if _, ok := c.Get([]byte("key")); ok {
+			log.Fatalf("%v: removed key is cached\n", p.name)
+		}
+		c.Add(nil, make([]byte, capacity))
+		if _, ok := c.Get(nil); ok {
+			log.Fatalf("%v: value larger than the cache was cached\n", p.name)
+		}
+	}
+}
+
+// zipfKeys returns n keys drawn from Zipf(s, v=2) over 1000 keys, with s=2
+// the query distribution of the index benchmarks. With scan, every 100 keys
+// drawn are followed by a scan of 100 keys that are never accessed again, so
+// at most half of the lookups can hit.
+func zipfKeys(n int, s float64, scan bool) [][]byte {
+	seededRand := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(seededRand, s, 2, 999)
+	keys := make([][]byte, 0, n)
+	for len(keys) < n {
+		keys = append(keys, []byte(strconv.FormatUint(zipf.Uint64(), 10)))
+		if scan && len(keys)%200 == 100 {
+			for i := 0; i < 100 && len(keys) < n; i++ {
+				keys = append(keys, []byte("scan"+strconv.Itoa(len(keys))))
+			}
+		}
+	}
+	return keys
+}
+
+// hitRatio looks up keys in c as Index.Get does, adding the missed ones.
+func hitRatio(c Cache, keys [][]byte) float64 {
+	value := bytes.Repeat([]byte("v"), 100)
+	hits := 0
+	for _, key := range keys {
+		if _, ok := c.Get(key); ok {
+			hits++
+			continue
+		}
+		c.Add(key, value)
+	}
+	return float64(hits) / float64(len(keys))
+}
+
+func TestTinyLFU_Scan(t *testing.T) {
+	keys := zipfKeys(100000, 2, true)
+	ratios := make(map[string]float64)
+	for _, p := range policies {
+		c, err := p.new(20 * (ENTRY_OVERHEAD + 104))
+		if err != nil {
+			log.Fatalf("create %v cache err: %v\n", p.name, err)
+		}
+		ratios[p.name] = hitRatio(c, keys)
+	}
+	if ratios["tinylfu"] <= ratios["lru"] {
+		log.Fatalf("hit ratio under scans, lru: %.3f, tinylfu: %.3f\n", ratios["lru"], ratios["tinylfu"])
+	}
+}
+
+// BenchmarkHitRatio reports the hit ratio of every policy with room for 20
+// and 100 of the 1000 keys, under Zipf workloads of various skews, and under
+// the Zipf workload of the index benchmarks with scans.
+func BenchmarkHitRatio(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	workloads := []struct {
+		name string
+		s    float64
+		scan bool
+	}{
+		{"zipf-1.1", 1.1, false},
+		{"zipf-1.5", 1.5, false},
+		{"zipf-2", 2, false},
+		{"zipf-3", 3, false},
+		{"zipf-2+scan", 2, true},
+	}
+	for _, workload := range workloads {
+		keys := zipfKeys(100000, workload.s, workload.scan)
+		for _, entries := range []int64{20, 100} {
+			for _, p := range policies {
+				b.Run(fmt.Sprintf("%v/%v/%v", workload.name, entries, p.name), func(b *testing.B) {
+					var ratio float64
+					for i := 0; i < b.N; i++ {
+						c, _ := p.new(entries * (ENTRY_OVERHEAD + 104))
+						ratio = hitRatio(c, keys)
+					}
+					b.ReportMetric(100*ratio, "hit%")
+				})
+			}
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	c, err := NewClock(3 * (ENTRY_OVERHEAD + 2))
+	if err != nil {
+		log.Fatalf("create cache err: %v\n", err)
+	}
+	for _, key := range []string{"1", "2", "3"} {
+		c.Add([]byte(key), []byte(key))
+	}
+	// the hand spares the referenced 1 and evicts 2
+	c.Get([]byte("1"))
+	c.Add([]byte("4"), []byte("4"))
+	for key, cached := range map[string]bool{"1": true, "2": false, "3": true, "4": true} {
+		if v, ok := c.Get([]byte(key)); ok != cached || ok && string(v) != key {
+			log.Fatalf("key %v cached: %v, value: %s\n", key, ok, v)
+		}
+	}
+	// the removed entry under the hand is skipped
+	c.Remove([]byte("3"))
+	c.Add([]byte("5"), []byte("5"))
+	c.Add([]byte("6"), []byte("6"))
+	if c.Len() != 3 || c.Stats().Bytes != 3*(ENTRY_OVERHEAD+2) {
+		log.Fatalf("cache of %v entries, %v bytes\n", c.Len(), c.Stats().Bytes)
+	}
+}
+
+// BenchmarkGet_Parallel looks up Zipf keys from 16 goroutines per CPU, as the
+// index looks them up from up to MaxRoutines goroutines. A single lock shows
+// with -cpu above 1, where sharded and CLOCK caches keep scaling.
+func BenchmarkGet_Parallel(b *testing.B) {
+	keys := zipfKeys(100000, 2, false)
+	value := bytes.Repeat([]byte("v"), 100)
+	shards := func(newShard func(capacity int64) (Cache, error)) func(capacity int64) (Cache, error) {
+		return func(capacity int64) (Cache, error) { return NewSharded(capacity, 16, newShard) }
+	}
+	lru := func(capacity int64) (Cache, error) { return NewLRU(capacity) }
+	clock := func(capacity int64) (Cache, error) { return NewClock(capacity) }
+	for _, p := range []struct {
+		name string
+		new  func(capacity int64) (Cache, error)
+	}{
+		{"lru", lru},
+		{"clock", clock},
+		{"lru-sharded", shards(lru)},
+		{"clock-sharded", shards(clock)},
+	} {
+		b.Run(p.name, func(b *testing.B) {
+			c, _ := p.new(64 << 20)
+			for _, key := range keys {
+				c.Add(key, value)
+			}
+			var start int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				n := int(atomic.AddInt64(&start, 7919))
+				for ; pb.Next(); n++ {
+					key := keys[n%len(keys)]
+					if _, ok := c.Get(key); !ok {
+						c.Add(key, value)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestExpiring(t *testing.T) {
+	lru, _ := NewLRU(1 << 10)
+	c := NewExpiring(lru, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.Add([]byte("key"), []byte("value"))
+	c.Add([]byte("absent"), nil)
+	if v, ok := c.Get([]byte("key")); !ok || string(v) != "value" {
+		log.Fatalf("fresh value: %q, cached: %v\n", v, ok)
+	}
+	if v, ok := c.Get([]byte("absent")); !ok || len(v) != 0 {
+		log.Fatalf("fresh empty value: %q, cached: %v\n", v, ok)
+	}
+
+	now = now.Add(time.Minute + time.Nanosecond)
+	if _, ok := c.Get([]byte("key")); ok {
+		log.Fatalln("expired value is cached")
+	}
+	if c.Len() != 1 {
+		log.Fatalf("expired value is kept, %v entries\n", c.Len())
+	}
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		log.Fatalf("stats: %+v\n", stats)
+	}
+}
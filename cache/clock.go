@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Clock approximates LRU with the CLOCK algorithm: a hit only sets the
+// referenced bit of its entry instead of moving it in a list, so Get takes a
+// read lock and hits proceed in parallel. To make room, the hand sweeps the
+// entries in a ring, clearing the referenced bits it finds set and evicting
+// the first entry whose bit is clear.
+type Clock struct {
+	// hits and misses are counted atomically by Get and come first to stay
+	// 64-bit aligned.
+	hits   int64
+	misses int64
+	mutex  sync.RWMutex
+	lists
+	values segment
+	// hand is the next entry to sweep, nil for the front of the ring
+	hand *list.Element
+}
+
+// NewClock returns a CLOCK cache holding at most capacity bytes.
+func NewClock(capacity int64) (*Clock, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrCapacity, capacity)
+	}
+	return &Clock{lists: newLists(), values: newSegment(capacity)}, nil
+}
+
+func (c *Clock) Get(key []byte) (value []byte, success bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	elem, exist := c.items[string(key)]
+	if !exist {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	e := elem.Value.(*entry)
+	// the bit is mostly set already on hot entries, so check it first to spare
+	// writes to their cache lines
+	if atomic.LoadUint32(&e.referenced) == 0 {
+		atomic.StoreUint32(&e.referenced, 1)
+	}
+	return e.value, true
+}
+
+// Add inserts value behind the hand, so that it is swept last.
+func (c *Clock) Add(key []byte, value []byte) {
+	e := newEntry(key, value)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[e.key]; exist {
+		c.unlink(elem)
+	}
+	if e.size > c.values.capacity {
+		return
+	}
+	c.stats.Adds++
+	for c.values.size+e.size > c.values.capacity {
+		elem := c.hand
+		if elem == nil {
+			elem = c.values.list.Front()
+		}
+		c.hand = elem.Next()
+		if atomic.LoadUint32(&elem.Value.(*entry).referenced) == 1 {
+			atomic.StoreUint32(&elem.Value.(*entry).referenced, 0)
+			continue
+		}
+		c.stats.Evictions++
+		c.remove(elem)
+	}
+	var elem *list.Element
+	if c.hand == nil {
+		elem = c.values.list.PushBack(e)
+	} else {
+		elem = c.values.list.InsertBefore(e, c.hand)
+	}
+	e.segment = &c.values
+	c.values.size += e.size
+	c.items[e.key] = elem
+}
+
+// unlink removes elem, moving the hand off it first.
+func (c *Clock) unlink(elem *list.Element) {
+	if elem == c.hand {
+		c.hand = elem.Next()
+	}
+	c.remove(elem)
+}
+
+func (c *Clock) Remove(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[string(key)]; exist {
+		c.unlink(elem)
+	}
+}
+
+func (c *Clock) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.values.list.Len()
+}
+
+func (c *Clock) Stats() Stats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	stats := c.snapshot(&c.values)
+	stats.Hits = atomic.LoadInt64(&c.hits)
+	stats.Misses = atomic.LoadInt64(&c.misses)
+	return stats
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// Expiring expires the values of a cache ttl after they were added. It keeps
+// the deadline of a value in front of it, 8 more bytes against the capacity
+// of the cache, and drops an expired value when it is looked up.
+type Expiring struct {
+	// expired counts the hits on expired values atomically, and comes first
+	// to stay 64-bit aligned.
+	expired int64
+	cache   Cache
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// NewExpiring returns c with its values expiring ttl after they were added.
+func NewExpiring(c Cache, ttl time.Duration) *Expiring {
+	return &Expiring{cache: c, ttl: ttl, now: time.Now}
+}
+
+func (c *Expiring) Add(key []byte, value []byte) {
+	buf := make([]byte, 8+len(value))
+	binary.LittleEndian.PutUint64(buf, uint64(c.now().Add(c.ttl).UnixNano()))
+	copy(buf[8:], value)
+	c.cache.Add(key, buf)
+}
+
+func (c *Expiring) Get(key []byte) (value []byte, success bool) {
+	buf, success := c.cache.Get(key)
+	if !success {
+		return nil, false
+	}
+	if c.now().UnixNano() > int64(binary.LittleEndian.Uint64(buf)) {
+		atomic.AddInt64(&c.expired, 1)
+		c.cache.Remove(key)
+		return nil, false
+	}
+	return buf[8:], true
+}
+
+func (c *Expiring) Remove(key []byte) {
+	c.cache.Remove(key)
+}
+
+// Len returns the number of cached values, including the expired values that
+// were not looked up since they expired.
+func (c *Expiring) Len() int {
+	return c.cache.Len()
+}
+
+// Stats returns the stats of the cache, counting the hits on expired values
+// as misses.
+func (c *Expiring) Stats() Stats {
+	stats := c.cache.Stats()
+	expired := atomic.LoadInt64(&c.expired)
+	stats.Hits -= expired
+	stats.Misses += expired
+	return stats
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LRU evicts the least recently used values. It is the simplest policy, and
+// suits workloads whose recent keys are the most likely to be accessed again.
+type LRU struct {
+	mutex sync.Mutex
+	lists
+	values segment
+}
+
+// NewLRU returns an LRU cache holding at most capacity bytes.
+func NewLRU(capacity int64) (*LRU, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrCapacity, capacity)
+	}
+	return &LRU{lists: newLists(), values: newSegment(capacity)}, nil
+}
+
+func (c *LRU) Add(key []byte, value []byte) {
+	e := newEntry(key, value)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[e.key]; exist {
+		c.remove(elem)
+	}
+	if e.size > c.values.capacity {
+		return
+	}
+	c.stats.Adds++
+	c.insert(e, &c.values)
+	for c.values.size > c.values.capacity {
+		c.evict(&c.values)
+	}
+}
+
+func (c *LRU) Get(key []byte) (value []byte, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exist := c.items[string(key)]
+	if !exist {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.values.list.MoveToFront(elem)
+	return elem.Value.(*entry).value, true
+}
+
+func (c *LRU) Remove(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[string(key)]; exist {
+		c.remove(elem)
+	}
+}
+
+func (c *LRU) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values.list.Len()
+}
+
+func (c *LRU) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.snapshot(&c.values)
+}
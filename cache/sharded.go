@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"fmt"
+	"hash/maphash"
+)
+
+// Sharded spreads the keys by hash over independent caches, each with its own
+// lock and an equal share of the capacity, so that lookups of different keys
+// rarely wait for each other.
+type Sharded struct {
+	seed   maphash.Seed
+	shards []Cache
+}
+
+// NewSharded returns a cache of shards caches holding at most capacity bytes
+// together, each made by newShard.
+func NewSharded(capacity int64, shards int, newShard func(capacity int64) (Cache, error)) (*Sharded, error) {
+	if shards <= 0 {
+		return nil, fmt.Errorf("%w: %v shards", ErrCapacity, shards)
+	}
+	c := &Sharded{seed: maphash.MakeSeed(), shards: make([]Cache, shards)}
+	for n := range c.shards {
+		shard, err := newShard(capacity / int64(shards))
+		if err != nil {
+			return nil, fmt.Errorf("shard %v: %w", n, err)
+		}
+		c.shards[n] = shard
+	}
+	return c, nil
+}
+
+func (c *Sharded) shard(key []byte) Cache {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	_, _ = h.Write(key)
+	return c.shards[h.Sum64()%uint64(len(c.shards))]
+}
+
+func (c *Sharded) Get(key []byte) (value []byte, success bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *Sharded) Add(key []byte, value []byte) {
+	c.shard(key).Add(key, value)
+}
+
+func (c *Sharded) Remove(key []byte) {
+	c.shard(key).Remove(key)
+}
+
+func (c *Sharded) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Stats sums the stats of the shards, which are taken one after the other.
+func (c *Sharded) Stats() Stats {
+	var stats Stats
+	for _, shard := range c.shards {
+		s := shard.Stats()
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.Adds += s.Adds
+		stats.Evictions += s.Evictions
+		stats.Entries += s.Entries
+		stats.Bytes += s.Bytes
+	}
+	return stats
+}
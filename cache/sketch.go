@@ -0,0 +1,91 @@
+package cache
+
+const (
+	// SKETCH_DEPTH is the number of counter rows of the frequency sketch.
+	SKETCH_DEPTH = 4
+	// SKETCH_MAX_COUNT is the largest count of a 4-bit counter.
+	SKETCH_MAX_COUNT = 15
+	// SKETCH_SAMPLE_FACTOR times the counters per row is the number of
+	// increments after which every counter is halved, so that the estimates
+	// follow the recent accesses rather than all of them.
+	SKETCH_SAMPLE_FACTOR = 10
+)
+
+var sketchSeeds = [SKETCH_DEPTH]uint64{
+	0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325,
+}
+
+// sketch is a count-min sketch of 4-bit counters estimating how often a key
+// hash was accessed recently.
+type sketch struct {
+	rows [SKETCH_DEPTH][]uint64
+	mask uint64
+	// additions counts the increments since the last halving
+	additions int
+	sample    int
+}
+
+// newSketch returns a sketch of at least width counters per row.
+func newSketch(width int64) *sketch {
+	w := int64(16)
+	for w < width {
+		w <<= 1
+	}
+	s := &sketch{mask: uint64(w - 1), sample: SKETCH_SAMPLE_FACTOR * int(w)}
+	for d := range s.rows {
+		s.rows[d] = make([]uint64, w/16)
+	}
+	return s
+}
+
+// bytes returns the memory taken by the counters.
+func (s *sketch) bytes() int64 {
+	return SKETCH_DEPTH * 8 * int64(len(s.rows[0]))
+}
+
+// counter returns the word and the shift of the counter of hash in row d.
+func (s *sketch) counter(hash uint64, d int) (word int, shift uint) {
+	h := (hash ^ sketchSeeds[d]) * 0x9e3779b97f4a7c15
+	idx := (h >> 32) & s.mask
+	return int(idx / 16), uint(idx%16) * 4
+}
+
+func (s *sketch) increment(hash uint64) {
+	added := false
+	for d := range s.rows {
+		word, shift := s.counter(hash, d)
+		if (s.rows[d][word]>>shift)&0xf < SKETCH_MAX_COUNT {
+			s.rows[d][word] += 1 << shift
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	s.additions++
+	if s.additions >= s.sample {
+		s.halve()
+	}
+}
+
+// estimate returns the smallest counter of hash, which overestimates its
+// accesses only when every row collides with a hotter hash.
+func (s *sketch) estimate(hash uint64) int {
+	count := SKETCH_MAX_COUNT
+	for d := range s.rows {
+		word, shift := s.counter(hash, d)
+		if c := int(s.rows[d][word]>>shift) & 0xf; c < count {
+			count = c
+		}
+	}
+	return count
+}
+
+func (s *sketch) halve() {
+	for d := range s.rows {
+		for n, word := range s.rows[d] {
+			s.rows[d][n] = (word >> 1) & 0x7777777777777777
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"hash/maphash"
+	"sync"
+)
+
+const (
+	// WINDOW_PERCENT of the capacity of a TinyLFU is its window, which takes
+	// every new value and lets a burst of new keys build up their frequency.
+	WINDOW_PERCENT = 1
+	// PROTECTED_PERCENT of the rest is the protected segment, holding the
+	// values hit again since they were admitted.
+	PROTECTED_PERCENT = 80
+)
+
+// TinyLFU is a W-TinyLFU cache. New values enter a small LRU window, and a
+// value leaving the window is only admitted into the main segmented LRU when
+// a count-min sketch estimates that its key was accessed more often recently
+// than the keys it would evict. One-off keys of a bulk scan thus pass through
+// the window without flushing the hot values.
+type TinyLFU struct {
+	mutex sync.Mutex
+	lists
+	capacity int64
+	seed     maphash.Seed
+	window   segment
+	// probation holds the values admitted from the window and the values
+	// demoted from protected, it takes the rest of the main capacity
+	probation segment
+	protected segment
+	sketch    *sketch
+}
+
+// NewTinyLFU returns a cache holding at most capacity bytes, including its
+// frequency sketch of about 2 bytes per ENTRY_OVERHEAD of capacity.
+func NewTinyLFU(capacity int64) (*TinyLFU, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrCapacity, capacity)
+	}
+	s := newSketch(capacity / ENTRY_OVERHEAD)
+	values := capacity - s.bytes()
+	if values <= 0 {
+		return nil, fmt.Errorf("%w: %v bytes cannot hold the frequency sketch", ErrCapacity, capacity)
+	}
+	window := values * WINDOW_PERCENT / 100
+	return &TinyLFU{
+		lists:     newLists(),
+		capacity:  values,
+		seed:      maphash.MakeSeed(),
+		window:    newSegment(window),
+		probation: newSegment(values - window),
+		protected: newSegment((values - window) * PROTECTED_PERCENT / 100),
+		sketch:    s,
+	}, nil
+}
+
+func (c *TinyLFU) hash(key []byte) uint64 {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+// Add caches value under key in the window, then moves the values overflowing
+// the window to the main segments if they are admitted.
+func (c *TinyLFU) Add(key []byte, value []byte) {
+	e := newEntry(key, value)
+	e.hash = c.hash(key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[e.key]; exist {
+		c.remove(elem)
+	}
+	if e.size > c.capacity {
+		return
+	}
+	c.stats.Adds++
+	c.insert(e, &c.window)
+	for c.window.size > c.window.capacity {
+		c.admit(c.window.list.Back())
+	}
+}
+
+// Get returns the value cached under key and records the access in the
+// sketch, hit or miss.
+func (c *TinyLFU) Get(key []byte) (value []byte, success bool) {
+	hash := c.hash(key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sketch.increment(hash)
+	elem, exist := c.items[string(key)]
+	if !exist {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	e := elem.Value.(*entry)
+	if e.segment != &c.probation {
+		e.segment.list.MoveToFront(elem)
+		return e.value, true
+	}
+	c.move(elem, &c.protected)
+	for c.protected.size > c.protected.capacity {
+		c.move(c.protected.list.Back(), &c.probation)
+	}
+	return e.value, true
+}
+
+// admit moves the window entry elem to probation if the main segments have
+// room for it, or if its key is estimated more frequent than every key that
+// would be evicted to make room, and evicts it otherwise.
+func (c *TinyLFU) admit(elem *list.Element) {
+	e := elem.Value.(*entry)
+	need := c.probation.size + c.protected.size + e.size - c.probation.capacity
+	victims := 0
+	if need > 0 {
+		freq := c.sketch.estimate(e.hash)
+		for v := c.victim(nil); need > 0; v = c.victim(v) {
+			if v == nil || c.sketch.estimate(v.Value.(*entry).hash) >= freq {
+				c.stats.Evictions++
+				c.remove(elem)
+				return
+			}
+			need -= v.Value.(*entry).size
+			victims++
+		}
+	}
+	for ; victims > 0; victims-- {
+		c.evict(c.victim(nil).Value.(*entry).segment)
+	}
+	c.move(elem, &c.probation)
+}
+
+// victim returns the entry evicted after prev, or the first one for nil: the
+// least recently used entries of probation, then those of protected.
+func (c *TinyLFU) victim(prev *list.Element) *list.Element {
+	if prev == nil {
+		if back := c.probation.list.Back(); back != nil {
+			return back
+		}
+		return c.protected.list.Back()
+	}
+	if elem := prev.Prev(); elem != nil {
+		return elem
+	}
+	if prev.Value.(*entry).segment == &c.probation {
+		return c.protected.list.Back()
+	}
+	return nil
+}
+
+func (c *TinyLFU) Remove(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[string(key)]; exist {
+		c.remove(elem)
+	}
+}
+
+func (c *TinyLFU) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.items)
+}
+
+// Stats returns the stats of the cache, whose Bytes leave out the sketch.
+func (c *TinyLFU) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.snapshot(&c.window, &c.probation, &c.protected)
+}
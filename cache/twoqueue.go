@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// RECENT_PERCENT of the values of a TwoQueue is the target of its recent
+	// segment, beyond which values are evicted from there first.
+	RECENT_PERCENT = 25
+	// GHOST_PERCENT of the capacity of TwoQueue and ARC holds the ghosts, the
+	// keys of recently evicted values.
+	GHOST_PERCENT = 10
+)
+
+// TwoQueue is a 2Q cache. New values enter the recent segment, and move to
+// the frequent segment when they are accessed again, or when they are added
+// again shortly after their eviction from recent, which ghosts remember.
+// Values accessed once are thus evicted before the frequent ones.
+type TwoQueue struct {
+	mutex sync.Mutex
+	lists
+	capacity    int64
+	recent      segment
+	frequent    segment
+	recentEvict segment
+}
+
+// New2Q returns a 2Q cache holding at most capacity bytes, including the
+// ghosts.
+func New2Q(capacity int64) (*TwoQueue, error) {
+	ghosts := capacity * GHOST_PERCENT / 100
+	if capacity-ghosts <= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrCapacity, capacity)
+	}
+	values := capacity - ghosts
+	return &TwoQueue{
+		lists:       newLists(),
+		capacity:    values,
+		recent:      newSegment(values * RECENT_PERCENT / 100),
+		frequent:    newSegment(values),
+		recentEvict: newSegment(ghosts),
+	}, nil
+}
+
+// Add caches value in frequent when its key is cached or remembered, and in
+// recent otherwise.
+func (c *TwoQueue) Add(key []byte, value []byte) {
+	e := newEntry(key, value)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	to := &c.recent
+	if elem, exist := c.items[e.key]; exist {
+		c.remove(elem)
+		to = &c.frequent
+	}
+	if e.size > c.capacity {
+		return
+	}
+	c.stats.Adds++
+	for c.recent.size+c.frequent.size+e.size > c.capacity {
+		if c.recent.size > 0 && (c.recent.size > c.recent.capacity || c.frequent.size == 0) {
+			c.ghost(c.recent.list.Back(), &c.recentEvict)
+		} else {
+			c.evict(&c.frequent)
+		}
+	}
+	for c.ghostBytes > c.recentEvict.capacity {
+		c.remove(c.recentEvict.list.Back())
+	}
+	c.insert(e, to)
+}
+
+func (c *TwoQueue) Get(key []byte) (value []byte, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exist := c.items[string(key)]
+	if !exist || elem.Value.(*entry).ghost {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	if elem.Value.(*entry).segment == &c.frequent {
+		c.frequent.list.MoveToFront(elem)
+	} else {
+		c.move(elem, &c.frequent)
+	}
+	return elem.Value.(*entry).value, true
+}
+
+func (c *TwoQueue) Remove(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exist := c.items[string(key)]; exist {
+		c.remove(elem)
+	}
+}
+
+func (c *TwoQueue) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.recent.list.Len() + c.frequent.list.Len()
+}
+
+func (c *TwoQueue) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.snapshot(&c.recent, &c.frequent)
+}
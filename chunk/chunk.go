@@ -1,113 +1,120 @@
 package chunk
 
 import (
-	"bytes"
-	"encoding/binary"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
 type Chunk struct {
 	id   int
+	path string
 	file *os.File
 	stat os.FileInfo
+
+	// set once the chunk is sealed
+	sealed bool
+	count  int64
+	fence  []uint64
+	// records of a sealed chunk mapped by Mmap
+	data []byte
+}
+
+// Path returns the name of the file backing chunk id in dir.
+func Path(dir string, id int) string {
+	return filepath.Join(dir, strconv.FormatInt(int64(id), 10)+"_chunk")
 }
 
-func New(id int) (c Chunk, err error) {
-	file, err := os.OpenFile(strconv.FormatInt(int64(id), 10)+"_chunk", os.O_CREATE|os.O_RDWR, 0777)
+// New opens chunk id in dir, creating it empty if needed. The file is opened
+// in append mode and only read with ReadAt, so a chunk may be read and
+// appended to by several goroutines at once.
+func New(dir string, id int) (c Chunk, err error) {
+	file, err := os.OpenFile(Path(dir, id), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0777)
 	if err != nil {
-		log.Fatalf("[chunk.chunk.New] open file %v_chunk error: %v",
-			strconv.FormatInt(int64(id), 10), err)
+		return c, fmt.Errorf("open chunk file: %w", err)
 	}
-	stat, _ := file.Stat()
-	return Chunk{
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return c, fmt.Errorf("stat chunk file: %w", err)
+	}
+	c = Chunk{
 		id:   id,
+		path: Path(dir, id),
 		file: file,
 		stat: stat,
-	}, nil
+	}
+	if err = c.loadFooter(); err != nil {
+		_ = file.Close()
+		return c, err
+	}
+	return c, nil
 }
 
 func (chunk *Chunk) Close() error {
+	if chunk.data != nil {
+		_ = munmap(chunk.data)
+		chunk.data = nil
+	}
 	return chunk.file.Close()
 }
 
-func (chunk *Chunk) Index(keyHash uint32) (offsets []uint64, err error) {
-	offsets = make([]uint64, 0)
-	err = chunk.file.Sync()
-	if err != nil {
-		log.Printf("[chunk.chunk.Index] file sync err: %v\n", err)
-		return offsets, err
+// Index returns the records of keyHash, which may belong to other keys.
+func (chunk *Chunk) Index(keyHash uint64) (records []Record, err error) {
+	if chunk.sealed {
+		return chunk.indexSealed(keyHash)
 	}
-	chunk.stat, err = chunk.file.Stat()
+	records = make([]Record, 0)
+	stat, err := chunk.file.Stat()
 	if err != nil {
 		log.Printf("[chunk.chunk.Index] stat update err: %v\n", err)
-		return offsets, err
-	}
-	// reset cursor
-	_, _ = chunk.file.Seek(0, 0)
-
-	curPos, err := chunk.file.Seek(0, 1)
-	if err != nil {
-		log.Printf("[chunk.chunk.Index] get current offset err: %v\n", err)
-		return offsets, err
+		return records, err
 	}
-	for curPos < chunk.stat.Size() {
-		buf := make([]byte, 8)
-		_, err = chunk.file.Read(buf)
+	buf := make([]byte, RECORD_SIZE)
+	for pos := int64(0); pos+RECORD_SIZE <= stat.Size(); pos += RECORD_SIZE {
+		_, err = chunk.file.ReadAt(buf, pos)
 		if err != nil {
 			log.Printf("[chunk.chunk.Index] read file err: %v, chunk: %v, keyHash: %v\n",
 				err, chunk.id, keyHash)
-			return offsets, err
-		}
-		hash, err := binary.ReadUvarint(bytes.NewBuffer(buf))
-		if err != nil {
-			log.Printf("[chunk.chunk.Index] chunk buffer err: %v\n", err)
-			return offsets, err
-		}
-		if uint32(hash) == keyHash {
-			offsetRec := make([]byte, 8)
-			_, err = chunk.file.Read(offsetRec)
-			if err != nil {
-				log.Printf("[chunk.chunk.Index] read offset buf err: %v, chunk: %v\n", err, chunk.id)
-				return offsets, err
-			}
-			newOffset, _ := binary.ReadUvarint(bytes.NewBuffer(offsetRec))
-			offsets = append(offsets, newOffset)
+			return records, err
 		}
-		curPos, err = chunk.file.Seek(0, 1)
-		if err != nil {
-			log.Printf("[chunk.chunk.Index] get current offset err: %v\n", err)
-			return offsets, nil
+		if rec := decodeRecord(buf); rec.Hash == keyHash {
+			records = append(records, rec)
 		}
 	}
-	log.Printf("[chunk.chunk.Index] Index success key: %v, offset: %v", keyHash, offsets)
-	return offsets, nil
+	log.Printf("[chunk.chunk.Index] Index success key: %v, records: %v", keyHash, records)
+	return records, nil
 }
 
-func (chunk *Chunk) Append(key uint32, value uint64) (err error) {
-	_ = chunk.file.Sync()
-	chunk.stat, err = chunk.file.Stat()
-	if err != nil {
-		log.Printf("[chunk.chunk.Append] update stat err: %v\n", err)
-		return err
+func (chunk *Chunk) Append(r Record) (err error) {
+	if chunk.sealed {
+		return ErrSealed
 	}
-
-	// EOF
-	_, _ = chunk.file.Seek(0, 2)
-
-	keyBuf := make([]byte, 8)
-	binary.PutUvarint(keyBuf, uint64(key))
-	valueBuf := make([]byte, 8)
-	binary.PutUvarint(valueBuf, value)
-	rec := make([]byte, 0)
-	rec = append(rec, keyBuf...)
-	rec = append(rec, valueBuf...)
+	rec := make([]byte, RECORD_SIZE)
+	r.encode(rec)
 	_, err = chunk.file.Write(rec)
 	if err != nil {
 		log.Printf("[chunk.chunk.Append] write file err: %v\n", err)
 		return err
 	}
-	log.Printf("[chunk.chunk.Append] append key: %v, value: %v to chunk %v", key, value, chunk.id)
+	log.Printf("[chunk.chunk.Append] append key: %v, value: %v to chunk %v", r.Hash, r.Offset, chunk.id)
+	return nil
+}
+
+// AppendBatch appends records with a single write, which the append mode
+// keeps whole when several goroutines append to the chunk.
+func (chunk *Chunk) AppendBatch(records []Record) error {
+	if chunk.sealed {
+		return ErrSealed
+	}
+	rec := make([]byte, RECORD_SIZE*len(records))
+	for i, r := range records {
+		r.encode(rec[RECORD_SIZE*i:])
+	}
+	if _, err := chunk.file.Write(rec); err != nil {
+		return fmt.Errorf("write chunk %v: %w", chunk.id, err)
+	}
 	return nil
 }
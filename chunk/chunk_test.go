@@ -1,34 +1,197 @@
 package chunk
 
 import (
+	"errors"
 	"log"
+	"math/rand"
 	"os"
-	"strconv"
 	"testing"
 )
 
 func TestChunk(t *testing.T) {
 	idx := 456789
-	c, err := New(idx)
+	c, err := New(".", idx)
 	if err != nil {
 		log.Fatalf("error open chunk %d", idx)
 	}
 	for i := 0; i < 100; i++ {
-		err = c.Append(uint32(i), uint64(i))
+		err = c.Append(Record{Hash: uint64(i), Fingerprint: uint32(i), KeySize: 1, ValueSize: uint32(i), Offset: uint64(i)})
 		if err != nil {
 			log.Fatalf("error append data: %v", i)
 		}
-		res, err := c.Index(uint32(i))
+		res, err := c.Index(uint64(i))
 		if err != nil {
 			log.Fatalf("error lookup data: %v", i)
 		}
 		for _, val := range res {
-			if val != uint64(i) {
+			if val.Offset != uint64(i) || val.Fingerprint != uint32(i) || val.KeySize != 1 || val.ValueSize != uint32(i) {
 				log.Fatalf("error lookup result: value: %v, should be: %v", val, i)
 			}
 		}
 	}
 
 	// clean
-	_ = os.Remove(strconv.FormatInt(int64(idx), 10) + "_chunk")
+	_ = os.Remove(Path(".", idx))
+}
+
+func TestSeal(t *testing.T) {
+	idx := 456790
+	c, err := New(".", idx)
+	if err != nil {
+		log.Fatalf("error open chunk %d", idx)
+	}
+	defer os.Remove(Path(".", idx))
+
+	// a run of equal hashes spanning several fence blocks, using all 64 bits
+	truth := make(map[uint64][]uint64)
+	for i := 0; i < 1000; i++ {
+		hash := uint64(rand.Intn(200)) << 40
+		if i%3 == 0 {
+			hash = 100 << 40
+		}
+		truth[hash] = append(truth[hash], uint64(i))
+		if err = c.Append(Record{Hash: hash, Fingerprint: uint32(i), Offset: uint64(i)}); err != nil {
+			log.Fatalf("error append data: %v", i)
+		}
+	}
+	if err = c.Seal(); err != nil {
+		log.Fatalf("error seal chunk: %v", err)
+	}
+	if err = c.Append(Record{Hash: 1, Offset: 1}); !errors.Is(err, ErrSealed) {
+		log.Fatalf("append to sealed chunk err: %v, want: %v", err, ErrSealed)
+	}
+	_ = c.Close()
+
+	c, err = New(".", idx)
+	if err != nil || !c.Sealed() {
+		log.Fatalf("error reopen sealed chunk, sealed: %v, err: %v", c.Sealed(), err)
+	}
+	defer c.Close()
+	for hash := uint64(0); hash < 210<<40; hash += 1 << 40 {
+		res, err := c.Index(hash)
+		if err != nil {
+			log.Fatalf("error lookup hash: %v, err: %v", hash, err)
+		}
+		if len(res) != len(truth[hash]) {
+			log.Fatalf("error lookup hash: %v, res: %v, should be: %v", hash, res, truth[hash])
+		}
+		for i := range res {
+			if res[i].Offset != truth[hash][i] || res[i].Fingerprint != uint32(res[i].Offset) {
+				log.Fatalf("error lookup hash: %v, res: %v, should be: %v", hash, res, truth[hash])
+			}
+		}
+	}
+}
+
+func TestSealFailure(t *testing.T) {
+	idx := 456793
+	c, err := New(".", idx)
+	if err != nil {
+		log.Fatalf("error open chunk %d", idx)
+	}
+	defer os.Remove(Path(".", idx))
+	defer c.Close()
+	if err = c.Append(Record{Hash: 1, Offset: 1}); err != nil {
+		log.Fatalf("error append data: %v", err)
+	}
+
+	// a directory in place of the sealed file fails the seal
+	if err = os.Mkdir(Path(".", idx)+".seal", 0777); err != nil {
+		log.Fatalf("error create directory: %v", err)
+	}
+	err = c.Seal()
+	_ = os.Remove(Path(".", idx) + ".seal")
+	if err == nil || c.Sealed() {
+		log.Fatalf("seal chunk sealed: %v, err: %v, want error", c.Sealed(), err)
+	}
+	if err = c.Append(Record{Hash: 2, Offset: 2}); err != nil {
+		log.Fatalf("error append data after failed seal: %v", err)
+	}
+	if err = c.Seal(); err != nil {
+		log.Fatalf("error seal chunk: %v", err)
+	}
+	for hash := uint64(1); hash <= 2; hash++ {
+		res, err := c.Index(hash)
+		if err != nil || len(res) != 1 || res[0].Offset != hash {
+			log.Fatalf("error lookup hash: %v, res: %v, err: %v", hash, res, err)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	idx := 456791
+	c, err := New(".", idx)
+	if err != nil {
+		log.Fatalf("error open chunk %d", idx)
+	}
+	defer os.Remove(Path(".", idx))
+	defer c.Close()
+	for i := 0; i < 300; i++ {
+		if err = c.Append(Record{Hash: uint64(300 - i), Offset: uint64(i)}); err != nil {
+			log.Fatalf("error append data: %v", i)
+		}
+	}
+	if err = c.Scan(func(Record) {}); err == nil {
+		log.Fatalf("scan of unsealed chunk succeeded")
+	}
+	if err = c.Seal(); err != nil {
+		log.Fatalf("error seal chunk: %v", err)
+	}
+	var last uint64
+	n := 0
+	err = c.Scan(func(r Record) {
+		if r.Hash < last || r.Hash+r.Offset != 300 {
+			log.Fatalf("error scan record: %v after hash %v", r, last)
+		}
+		last = r.Hash
+		n++
+	})
+	if err != nil || n != 300 || c.Count() != 300 {
+		log.Fatalf("error scan chunk: %v records, count: %v, err: %v", n, c.Count(), err)
+	}
+}
+
+func TestMmap(t *testing.T) {
+	idx := 456792
+	c, err := New(".", idx)
+	if err != nil {
+		log.Fatalf("error open chunk %d", idx)
+	}
+	defer os.Remove(Path(".", idx))
+	if err = c.Mmap(); err == nil {
+		log.Fatalf("mmap of unsealed chunk succeeded")
+	}
+	for i := 0; i < 1000; i++ {
+		if err = c.Append(Record{Hash: uint64(i % 300), Offset: uint64(i)}); err != nil {
+			log.Fatalf("error append data: %v", i)
+		}
+	}
+	if err = c.Seal(); err != nil {
+		log.Fatalf("error seal chunk: %v", err)
+	}
+	want := make(map[uint64][]Record)
+	for hash := uint64(0); hash < 310; hash++ {
+		if want[hash], err = c.Index(hash); err != nil {
+			log.Fatalf("error lookup hash: %v, err: %v", hash, err)
+		}
+	}
+	if err = c.Mmap(); errors.Is(err, ErrMmapUnsupported) {
+		t.Skip(err)
+	} else if err != nil || !c.Mapped() {
+		log.Fatalf("error mmap chunk, mapped: %v, err: %v", c.Mapped(), err)
+	}
+	for hash := uint64(0); hash < 310; hash++ {
+		res, err := c.Index(hash)
+		if err != nil || len(res) != len(want[hash]) {
+			log.Fatalf("error mapped lookup hash: %v, res: %v, should be: %v, err: %v", hash, res, want[hash], err)
+		}
+		for i := range res {
+			if res[i] != want[hash][i] {
+				log.Fatalf("error mapped lookup hash: %v, res: %v, should be: %v", hash, res, want[hash])
+			}
+		}
+	}
+	if err = c.Close(); err != nil || c.Mapped() {
+		log.Fatalf("error close mapped chunk, mapped: %v, err: %v", c.Mapped(), err)
+	}
 }
@@ -0,0 +1,21 @@
+package chunk
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// mmap maps the first size bytes of the chunk file read only. Lookups touch
+// a block here and there, so read ahead is turned off.
+func (chunk *Chunk) mmap(size int64) ([]byte, error) {
+	data, err := syscall.Mmap(int(chunk.file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap chunk %v: %w", chunk.id, err)
+	}
+	_ = syscall.Madvise(data, syscall.MADV_RANDOM)
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package chunk
+
+import "fmt"
+
+func (chunk *Chunk) mmap(size int64) ([]byte, error) {
+	return nil, fmt.Errorf("mmap chunk %v: %w", chunk.id, ErrMmapUnsupported)
+}
+
+func munmap(data []byte) error {
+	return nil
+}
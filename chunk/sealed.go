@@ -0,0 +1,367 @@
+package chunk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A sealed chunk holds its records sorted by hash with a fixed width, followed
+// by the fence and the footer:
+//
+//	record: hash uint64 | fingerprint uint32 | key size uint32 |
+//	        value size uint32 | offset uint64
+//	fence:  hash uint64 of every FENCE_INTERVAL-th record
+//	footer: record count uint64 | SEAL_MAGIC
+//
+// The fence is kept in memory, so a lookup binary searches it and reads a
+// single block of FENCE_INTERVAL records.
+const (
+	RECORD_SIZE    = 28
+	FENCE_INTERVAL = 128
+	FOOTER_SIZE    = 16
+	SEAL_MAGIC     = "IKVSEAL4"
+)
+
+var (
+	ErrSealed        = errors.New("chunk: sealed")
+	ErrCorruptSealed = errors.New("chunk: corrupt sealed file")
+	ErrUnsorted      = errors.New("chunk: records not sorted by hash")
+	// ErrMmapUnsupported is returned by Mmap outside of Linux.
+	ErrMmapUnsupported = errors.New("chunk: mmap not supported on this platform")
+)
+
+// Record locates one key of the data file. Fingerprint is a second hash of
+// the key, independent of Hash, that tells apart most keys sharing a Hash
+// without reading them. With the sizes of the key and the value, the whole
+// record is read at once.
+type Record struct {
+	Hash        uint64
+	Fingerprint uint32
+	KeySize     uint32
+	ValueSize   uint32
+	Offset      uint64
+}
+
+// encode writes r to buf, in the same layout for the append log and for a
+// sealed chunk.
+func (r Record) encode(buf []byte) {
+	binary.LittleEndian.PutUint64(buf, r.Hash)
+	binary.LittleEndian.PutUint32(buf[8:], r.Fingerprint)
+	binary.LittleEndian.PutUint32(buf[12:], r.KeySize)
+	binary.LittleEndian.PutUint32(buf[16:], r.ValueSize)
+	binary.LittleEndian.PutUint64(buf[20:], r.Offset)
+}
+
+func decodeRecord(buf []byte) Record {
+	return Record{
+		Hash:        binary.LittleEndian.Uint64(buf),
+		Fingerprint: binary.LittleEndian.Uint32(buf[8:]),
+		KeySize:     binary.LittleEndian.Uint32(buf[12:]),
+		ValueSize:   binary.LittleEndian.Uint32(buf[16:]),
+		Offset:      binary.LittleEndian.Uint64(buf[20:]),
+	}
+}
+
+func fenceSize(count int64) int64 {
+	return (count + FENCE_INTERVAL - 1) / FENCE_INTERVAL
+}
+
+// Sealed reports whether the chunk has been sealed.
+func (chunk *Chunk) Sealed() bool {
+	return chunk.sealed
+}
+
+// loadFooter detects a sealed file and loads its fence. An appendable chunk
+// never ends with SEAL_MAGIC since its last byte is the most significant byte
+// of an offset, which is zero for any data file below 64PB.
+func (chunk *Chunk) loadFooter() error {
+	size := chunk.stat.Size()
+	if size < FOOTER_SIZE {
+		return nil
+	}
+	footer := make([]byte, FOOTER_SIZE)
+	if _, err := chunk.file.ReadAt(footer, size-FOOTER_SIZE); err != nil {
+		return fmt.Errorf("read footer: %w", err)
+	}
+	if string(footer[8:]) != SEAL_MAGIC {
+		return nil
+	}
+	count := int64(binary.LittleEndian.Uint64(footer))
+	if count*RECORD_SIZE+fenceSize(count)*8+FOOTER_SIZE != size {
+		return fmt.Errorf("%w: %v records in %v bytes", ErrCorruptSealed, count, size)
+	}
+	buf := make([]byte, fenceSize(count)*8)
+	if _, err := chunk.file.ReadAt(buf, count*RECORD_SIZE); err != nil {
+		return fmt.Errorf("read fence: %w", err)
+	}
+	chunk.fence = make([]uint64, fenceSize(count))
+	for i := range chunk.fence {
+		chunk.fence[i] = binary.LittleEndian.Uint64(buf[i*8:])
+	}
+	chunk.count = count
+	chunk.sealed = true
+	return nil
+}
+
+// Seal rewrites the appended records of the chunk sorted by hash. The chunk
+// is read only afterwards.
+func (chunk *Chunk) Seal() error {
+	if chunk.sealed {
+		return nil
+	}
+	records, err := chunk.readLog()
+	if err != nil {
+		return err
+	}
+	sort.Slice(records, func(a, b int) bool {
+		if records[a].Hash != records[b].Hash {
+			return records[a].Hash < records[b].Hash
+		}
+		return records[a].Offset < records[b].Offset
+	})
+
+	w, err := newWriter(chunk.path)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err = w.Write(r); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return chunk.reopen()
+}
+
+// reopen replaces the file of the chunk with the sealed file moved in its
+// place. The chunk keeps its previous file, still holding the appended
+// records, until the sealed file is loaded.
+func (chunk *Chunk) reopen() error {
+	file, stat := chunk.file, chunk.stat
+	err := chunk.openSealed()
+	if err != nil {
+		if chunk.file != file {
+			_ = chunk.file.Close()
+		}
+		chunk.file, chunk.stat = file, stat
+		chunk.sealed, chunk.count, chunk.fence = false, 0, nil
+		return err
+	}
+	_ = file.Close()
+	return nil
+}
+
+func (chunk *Chunk) openSealed() (err error) {
+	file, err := os.OpenFile(chunk.path, os.O_RDWR, 0777)
+	if err != nil {
+		return fmt.Errorf("open chunk file: %w", err)
+	}
+	chunk.file = file
+	chunk.stat, err = file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat chunk file: %w", err)
+	}
+	return chunk.loadFooter()
+}
+
+// readLog loads every record appended to an unsealed chunk.
+func (chunk *Chunk) readLog() ([]Record, error) {
+	stat, err := chunk.file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat chunk file: %w", err)
+	}
+	records := make([]Record, 0, stat.Size()/RECORD_SIZE)
+	r := bufio.NewReader(io.NewSectionReader(chunk.file, 0, stat.Size()))
+	buf := make([]byte, RECORD_SIZE)
+	for {
+		if _, err = io.ReadFull(r, buf); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read chunk %v: %w", chunk.id, err)
+		}
+		records = append(records, decodeRecord(buf))
+	}
+}
+
+// Writer writes a sealed chunk from records sorted by hash, without holding
+// them in memory. The chunk file is replaced only by Close.
+type Writer struct {
+	path  string
+	file  *os.File
+	bw    *bufio.Writer
+	buf   []byte
+	count int64
+	last  uint64
+	fence []uint64
+}
+
+// NewWriter starts the sealed chunk id in dir.
+func NewWriter(dir string, id int) (*Writer, error) {
+	return newWriter(Path(dir, id))
+}
+
+func newWriter(path string) (*Writer, error) {
+	file, err := os.OpenFile(path+".seal", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
+	if err != nil {
+		return nil, fmt.Errorf("create sealed file: %w", err)
+	}
+	return &Writer{
+		path:  path,
+		file:  file,
+		bw:    bufio.NewWriter(file),
+		buf:   make([]byte, RECORD_SIZE),
+		fence: make([]uint64, 0),
+	}, nil
+}
+
+// Write appends r, whose hash must not be lower than the previous one.
+func (w *Writer) Write(r Record) error {
+	if w.count > 0 && r.Hash < w.last {
+		return fmt.Errorf("%w: hash %v after %v", ErrUnsorted, r.Hash, w.last)
+	}
+	if w.count%FENCE_INTERVAL == 0 {
+		w.fence = append(w.fence, r.Hash)
+	}
+	r.encode(w.buf)
+	if _, err := w.bw.Write(w.buf); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	w.count++
+	w.last = r.Hash
+	return nil
+}
+
+// Close writes the fence and the footer, then moves the sealed file in place
+// of the chunk file.
+func (w *Writer) Close() error {
+	for _, hash := range w.fence {
+		binary.LittleEndian.PutUint64(w.buf, hash)
+		if _, err := w.bw.Write(w.buf[:8]); err != nil {
+			w.Abort()
+			return fmt.Errorf("write fence: %w", err)
+		}
+	}
+	binary.LittleEndian.PutUint64(w.buf, uint64(w.count))
+	if _, err := w.bw.Write(w.buf[:8]); err != nil {
+		w.Abort()
+		return fmt.Errorf("write footer: %w", err)
+	}
+	if _, err := w.bw.WriteString(SEAL_MAGIC); err != nil {
+		w.Abort()
+		return fmt.Errorf("write footer: %w", err)
+	}
+	if err := w.bw.Flush(); err != nil {
+		w.Abort()
+		return fmt.Errorf("flush sealed file: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return fmt.Errorf("sync sealed file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return fmt.Errorf("close sealed file: %w", err)
+	}
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		_ = os.Remove(w.file.Name())
+		return fmt.Errorf("replace chunk file: %w", err)
+	}
+	return nil
+}
+
+// Abort drops the sealed file, leaving the chunk file untouched.
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// Count returns the number of records of a sealed chunk.
+func (chunk *Chunk) Count() int64 {
+	return chunk.count
+}
+
+// Scan calls fn with every record of a sealed chunk in hash order.
+func (chunk *Chunk) Scan(fn func(Record)) error {
+	if !chunk.sealed {
+		return fmt.Errorf("scan chunk %v: not sealed", chunk.id)
+	}
+	r := bufio.NewReader(io.NewSectionReader(chunk.file, 0, chunk.count*RECORD_SIZE))
+	buf := make([]byte, RECORD_SIZE)
+	for n := int64(0); n < chunk.count; n++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("scan chunk %v: %w", chunk.id, err)
+		}
+		fn(decodeRecord(buf))
+	}
+	return nil
+}
+
+// Mmap maps the records of a sealed chunk into memory, lookups then read them
+// from the page cache without any system call. The mapping is released by
+// Close.
+func (chunk *Chunk) Mmap() error {
+	if !chunk.sealed {
+		return fmt.Errorf("mmap chunk %v: not sealed", chunk.id)
+	}
+	if chunk.data != nil || chunk.count == 0 {
+		return nil
+	}
+	data, err := chunk.mmap(chunk.count * RECORD_SIZE)
+	if err != nil {
+		return err
+	}
+	chunk.data = data
+	return nil
+}
+
+// Mapped reports whether the chunk is read through Mmap.
+func (chunk *Chunk) Mapped() bool {
+	return chunk.data != nil
+}
+
+// indexSealed finds the block that may hold keyHash through the fence, then
+// binary searches it.
+func (chunk *Chunk) indexSealed(keyHash uint64) ([]Record, error) {
+	records := make([]Record, 0)
+	block := sort.Search(len(chunk.fence), func(i int) bool {
+		return chunk.fence[i] >= keyHash
+	})
+	// records equal to keyHash may start at the end of the previous block
+	if block > 0 {
+		block--
+	}
+	var buf []byte
+	if chunk.data == nil {
+		buf = make([]byte, FENCE_INTERVAL*RECORD_SIZE)
+	}
+	for start := int64(block) * FENCE_INTERVAL; start < chunk.count; start += FENCE_INTERVAL {
+		n := chunk.count - start
+		if n > FENCE_INTERVAL {
+			n = FENCE_INTERVAL
+		}
+		if chunk.data != nil {
+			buf = chunk.data[start*RECORD_SIZE : (start+n)*RECORD_SIZE]
+		} else if _, err := chunk.file.ReadAt(buf[:n*RECORD_SIZE], start*RECORD_SIZE); err != nil {
+			return records, fmt.Errorf("read block of chunk %v: %w", chunk.id, err)
+		}
+		i := sort.Search(int(n), func(i int) bool {
+			return decodeRecord(buf[i*RECORD_SIZE:]).Hash >= keyHash
+		})
+		for ; i < int(n); i++ {
+			r := decodeRecord(buf[i*RECORD_SIZE:])
+			if r.Hash != keyHash {
+				return records, nil
+			}
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
@@ -1,5 +1,3 @@
 module github.com/tabVersion/index-kv
 
-go 1.15
-
-require github.com/hashicorp/golang-lru v0.5.4
+go 1.21
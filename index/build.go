@@ -0,0 +1,400 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/tabVersion/index-kv/chunk"
+)
+
+const (
+	// BUILD_SEGMENT_SIZE is the amount of the data file read at once and
+	// handed to one build worker.
+	BUILD_SEGMENT_SIZE = 4 << 20
+	// BUILD_BATCH_SIZE is the number of records a worker buffers for one
+	// chunk before appending them.
+	BUILD_BATCH_SIZE = 512
+	// BUILD_SYNC_SIZE bounds the bytes read after a cut of the data file to
+	// find the record boundary following it.
+	BUILD_SYNC_SIZE = 4 << 20
+)
+
+// buildSegmentSize is BUILD_SEGMENT_SIZE, lowered by tests to spread small
+// data files over several workers.
+var buildSegmentSize = BUILD_SEGMENT_SIZE
+
+// segment is a range of the data file cut at record boundaries.
+type segment struct {
+	offset int64
+	data   []byte
+	// starts holds the position of every record within data
+	starts []int
+}
+
+// builder ingests the data file into the chunks of an index. BuildReaders
+// readers walk ranges of the file cut at record boundaries and cut them into
+// segments. Workers hash the keys of whole segments in parallel. With
+// BuildAppend they buffer the records per chunk, so a chunk file sees one
+// write per batch instead of one per record; BuildExternalSort is in
+// external.go.
+type builder struct {
+	// collected counts the keys collected for BackendMPH atomically, and
+	// comes first to stay 64-bit aligned
+	collected int64
+	index     *Index
+	mutex     sync.Mutex
+	err       error
+
+	// sorted runs of BuildExternalSort
+	runDir string
+	runs   []string
+	// keys collected for BackendMPH
+	pairs []chunk.Record
+}
+
+func (i *Index) build() error {
+	dataSource, err := os.Open(i.opts.DataFile)
+	if err != nil {
+		return fmt.Errorf("open data source %v: %w", i.opts.DataFile, err)
+	}
+	defer dataSource.Close()
+
+	b := &builder{index: i}
+	perfect := i.opts.Backend == BackendMPH
+	external := !perfect && i.opts.BuildMode == BuildExternalSort
+	if external {
+		if b.runDir, err = ioutil.TempDir(i.opts.IndexDir, "runs"); err != nil {
+			return fmt.Errorf("create run dir: %w", err)
+		}
+		defer os.RemoveAll(b.runDir)
+	}
+	segments := make(chan segment, i.opts.BuildWorkers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < i.opts.BuildWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if perfect {
+				b.collect(segments)
+			} else if external {
+				b.sortIngest(segments)
+			} else {
+				b.ingest(segments)
+			}
+		}()
+	}
+	readErr := b.read(dataSource, segments)
+	close(segments)
+	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if perfect {
+		return b.buildMPH()
+	}
+	if external {
+		return b.merge()
+	}
+	return b.seal()
+}
+
+// recordSize parses the record at the head of buf. ok is false when buf is
+// too short to hold the whole record.
+func (b *builder) recordSize(buf []byte) (keySize int, size int, ok bool, err error) {
+	if len(buf) < 8 {
+		return 0, 0, false, nil
+	}
+	ks, err := binary.ReadUvarint(bytes.NewBuffer(buf[:8]))
+	if err != nil || ks < uint64(b.index.opts.MinKeySize) || ks > uint64(b.index.opts.MaxKeySize) {
+		return 0, 0, false, fmt.Errorf("%w: key size %v, err: %v", ErrCorruptRecord, ks, err)
+	}
+	if len(buf) < 16+int(ks) {
+		return 0, 0, false, nil
+	}
+	vs, err := binary.ReadUvarint(bytes.NewBuffer(buf[8+ks : 16+ks]))
+	if err != nil || vs < uint64(b.index.opts.MinValueSize) || vs > uint64(b.index.opts.MaxValueSize) {
+		return 0, 0, false, fmt.Errorf("%w: value size %v, err: %v", ErrCorruptRecord, vs, err)
+	}
+	size = 16 + int(ks) + int(vs)
+	return int(ks), size, len(buf) >= size, nil
+}
+
+// read cuts the data file into BuildReaders ranges of whole records and
+// splits them concurrently.
+func (b *builder) read(f *os.File, segments chan<- segment) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat data source: %w", err)
+	}
+	bounds, err := b.boundaries(f, stat.Size())
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(bounds)-1)
+	wg := sync.WaitGroup{}
+	for r := range errs {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			start, end := bounds[r], bounds[r+1]
+			if errs[r] = b.split(io.NewSectionReader(f, start, end-start), start, segments); errs[r] != nil {
+				// stop the other readers
+				b.fail(errs[r])
+			}
+		}(r)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boundaries returns the offsets cutting the data file of size bytes into up
+// to BuildReaders ranges of whole records, from 0 to size. A cut whose record
+// boundary is not found is dropped, leaving its range to the previous reader.
+func (b *builder) boundaries(f io.ReaderAt, size int64) ([]int64, error) {
+	readers := int64(b.index.opts.BuildReaders)
+	bounds := []int64{0}
+	for r := int64(1); r < readers; r++ {
+		cut := size * r / readers
+		if cut <= bounds[len(bounds)-1] {
+			continue
+		}
+		bound, ok, err := b.sync(f, cut, size)
+		if err != nil {
+			return nil, err
+		}
+		if ok && bound > bounds[len(bounds)-1] && bound < size {
+			bounds = append(bounds, bound)
+		}
+	}
+	return append(bounds, size), nil
+}
+
+// sync finds a record boundary after cut. Records carry no sync marker and
+// bytes of a value may parse as a record, so it follows the chain of records
+// starting at every position up to the size of the largest record after cut.
+// One of them starts at the first boundary and, the data being valid, goes on
+// through the records of the file. Chains meeting at a position go on as one,
+// so once a single chain is left it is that one, and every position it went
+// through since it last met another chain is a record boundary. ok is false
+// when a single chain is not left within BUILD_SYNC_SIZE bytes, as happens
+// when values are as short as keys: the header of a value and the value, then
+// the header of the next key and the key, parse as a record as well.
+func (b *builder) sync(f io.ReaderAt, cut, size int64) (bound int64, ok bool, err error) {
+	maxRecord := 16 + b.index.opts.MaxKeySize + b.index.opts.MaxValueSize
+	end := cut + BUILD_SYNC_SIZE
+	if end > size {
+		end = size
+	}
+	buf := make([]byte, end-cut)
+	if _, err = f.ReadAt(buf, cut); err != nil {
+		return 0, false, fmt.Errorf("read data source at %v: %w", cut, err)
+	}
+	// origin[p] is -1 unless a chain reaches the position p after cut, and
+	// then the position since which that chain has not met another one
+	origin := make([]int32, len(buf)+1)
+	for p := range origin {
+		origin[p] = -1
+	}
+	chains := 0
+	for p := 0; p < len(buf); p++ {
+		from := origin[p]
+		if from >= 0 {
+			origin[p] = -1
+			chains--
+		}
+		if p < maxRecord {
+			// a chain starts at p, meeting the one reaching it if any
+			from = int32(p)
+		} else if from < 0 {
+			continue
+		}
+		if end < size && p+maxRecord > len(buf) {
+			// the record at p may end past buf
+			return 0, false, nil
+		}
+		_, n, whole, err := b.recordSize(buf[p:])
+		if err == nil && whole {
+			if next := p + n; origin[next] >= 0 {
+				origin[next] = int32(next)
+			} else {
+				origin[next] = from
+				chains++
+			}
+		}
+		if p >= maxRecord-1 && chains == 1 {
+			for q := p + 1; ; q++ {
+				if origin[q] >= 0 {
+					return cut + int64(origin[q]), true, nil
+				}
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// split reads a range of the data file starting at offset sequentially and
+// sends it to the workers in segments holding whole records.
+func (b *builder) split(f io.Reader, offset int64, segments chan<- segment) error {
+	bufSize := buildSegmentSize
+	if maxRecord := 16 + b.index.opts.MaxKeySize + b.index.opts.MaxValueSize; bufSize < 2*maxRecord {
+		bufSize = 2 * maxRecord
+	}
+	var left []byte
+	for {
+		buf := make([]byte, bufSize)
+		n := copy(buf, left)
+		read, err := io.ReadFull(f, buf[n:])
+		n += read
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return fmt.Errorf("read data source at %v: %w", offset+int64(n-read), err)
+		}
+		buf = buf[:n]
+
+		starts := make([]int, 0)
+		pos := 0
+		for {
+			_, size, ok, err := b.recordSize(buf[pos:])
+			if err != nil {
+				return fmt.Errorf("record at %v: %w", offset+int64(pos), err)
+			}
+			if !ok {
+				break
+			}
+			starts = append(starts, pos)
+			pos += size
+		}
+		if len(starts) > 0 {
+			segments <- segment{offset: offset, data: buf[:pos], starts: starts}
+		}
+		if b.failed() {
+			return nil
+		}
+		left = buf[pos:]
+		offset += int64(pos)
+		if eof {
+			if len(left) > 0 {
+				return fmt.Errorf("%w: truncated record at %v", ErrCorruptRecord, offset)
+			}
+			return nil
+		}
+	}
+}
+
+// ingest hashes the keys of segments and appends them to their chunks.
+func (b *builder) ingest(segments <-chan segment) {
+	batches := make(map[uint32][]chunk.Record)
+	for seg := range segments {
+		if b.failed() {
+			continue
+		}
+		for _, pos := range seg.starts {
+			r := b.record(seg, pos)
+			chunkId := b.index.chunkOf(r.Hash)
+			batches[chunkId] = append(batches[chunkId], r)
+			if len(batches[chunkId]) >= BUILD_BATCH_SIZE {
+				b.flush(chunkId, batches[chunkId])
+				batches[chunkId] = batches[chunkId][:0]
+			}
+		}
+	}
+	for chunkId, batch := range batches {
+		if len(batch) > 0 {
+			b.flush(chunkId, batch)
+		}
+	}
+}
+
+// record returns the chunk record of the data file record at pos in seg.
+func (b *builder) record(seg segment, pos int) chunk.Record {
+	keySize, size, _, _ := b.recordSize(seg.data[pos:])
+	key := seg.data[pos+8 : pos+8+keySize]
+	return chunk.Record{
+		Hash:        b.index.opts.Hasher.Sum64(key),
+		Fingerprint: fingerprint(key),
+		KeySize:     uint32(keySize),
+		ValueSize:   uint32(size - 16 - keySize),
+		Offset:      uint64(seg.offset + int64(pos)),
+	}
+}
+
+func (b *builder) flush(chunkId uint32, batch []chunk.Record) {
+	dataChunk, err := b.chunk(chunkId)
+	if err == nil {
+		err = dataChunk.AppendBatch(batch)
+	}
+	if err != nil {
+		b.fail(fmt.Errorf("append to chunk %v: %w", chunkId, err))
+	}
+}
+
+// chunk returns the chunk for chunkId, creating it on first use.
+func (b *builder) chunk(chunkId uint32) (*chunk.Chunk, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if c := b.index.findChunk(chunkId); c != nil {
+		return c, nil
+	}
+	c, err := chunk.New(b.index.opts.IndexDir, int(chunkId))
+	if err != nil {
+		return nil, fmt.Errorf("create chunk %v: %w", chunkId, err)
+	}
+	if err = b.index.addChunk(chunkId, &c); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return &c, nil
+}
+
+// seal seals every chunk, BuildWorkers at a time.
+func (b *builder) seal() error {
+	ids := make(chan uint32)
+	wg := sync.WaitGroup{}
+	for w := 0; w < b.index.opts.BuildWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunkId := range ids {
+				c := b.index.findChunk(chunkId)
+				if err := c.Seal(); err != nil {
+					b.fail(fmt.Errorf("seal chunk %v: %w", chunkId, err))
+				} else if err = b.index.mapChunk(chunkId, c); err != nil {
+					b.fail(err)
+				}
+			}
+		}()
+	}
+	for chunkId := range b.index.chunkIds {
+		ids <- chunkId
+	}
+	close(ids)
+	wg.Wait()
+	return b.err
+}
+
+func (b *builder) fail(err error) {
+	b.mutex.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.mutex.Unlock()
+}
+
+func (b *builder) failed() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.err != nil
+}
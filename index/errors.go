@@ -0,0 +1,18 @@
+package index
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the key is not in the data file.
+	ErrNotFound = errors.New("key not found")
+	// ErrEmptyKey is returned for keys shorter than MIN_KEY_SIZE.
+	ErrEmptyKey = errors.New("key too small")
+	// ErrKeyTooLarge is returned for keys longer than MAX_KEY_SIZE.
+	ErrKeyTooLarge = errors.New("key too large")
+	// ErrCorruptRecord is returned when a record of the data file cannot be
+	// decoded or its sizes are out of range.
+	ErrCorruptRecord = errors.New("corrupt record")
+	// ErrBuildMemory is returned by New when the build would take more than
+	// Options.BuildMemory, as BackendMPH does with too many keys.
+	ErrBuildMemory = errors.New("build exceeds memory budget")
+)
@@ -0,0 +1,266 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/tabVersion/index-kv/chunk"
+)
+
+const (
+	// RUN_ENTRY_SIZE is the size of a runEntry, both in memory and in a run
+	// file: chunk id uint32 | fingerprint uint32 | hash uint64 | offset uint64 |
+	// key size uint32 | value size uint32.
+	RUN_ENTRY_SIZE = 32
+	// RUN_BUFFER_SIZE is the buffer size of each run while writing or merging.
+	RUN_BUFFER_SIZE = 64 << 10
+)
+
+// runEntry is a chunk record tagged with its chunk, ordered by chunk then
+// hash so that a merged stream of runs fills the chunks one after another.
+type runEntry struct {
+	chunkId     uint32
+	fingerprint uint32
+	hash        uint64
+	offset      uint64
+	keySize     uint32
+	valueSize   uint32
+}
+
+func (e runEntry) less(o runEntry) bool {
+	if e.chunkId != o.chunkId {
+		return e.chunkId < o.chunkId
+	}
+	if e.hash != o.hash {
+		return e.hash < o.hash
+	}
+	return e.offset < o.offset
+}
+
+func (e runEntry) record() chunk.Record {
+	return chunk.Record{
+		Hash:        e.hash,
+		Fingerprint: e.fingerprint,
+		KeySize:     e.keySize,
+		ValueSize:   e.valueSize,
+		Offset:      e.offset,
+	}
+}
+
+// sortIngest is the ingest of BuildExternalSort: each worker fills a buffer of
+// up to BuildMemory / BuildWorkers bytes and spills it to a sorted run whenever
+// it is full, so the memory used by a build does not depend on the data file.
+// The buffer grows as entries come, so that a small data file takes little.
+func (b *builder) sortIngest(segments <-chan segment) {
+	capacity := b.index.opts.BuildMemory / int64(b.index.opts.BuildWorkers) / RUN_ENTRY_SIZE
+	if capacity < 1 {
+		capacity = 1
+	}
+	var entries []runEntry
+	for seg := range segments {
+		if b.failed() {
+			continue
+		}
+		for _, pos := range seg.starts {
+			if len(entries) == cap(entries) {
+				// double the buffer up to its capacity
+				size := 2*int64(cap(entries)) + 1
+				if size > capacity {
+					size = capacity
+				}
+				entries = append(make([]runEntry, 0, size), entries...)
+			}
+			r := b.record(seg, pos)
+			entries = append(entries, runEntry{
+				chunkId:     b.index.chunkOf(r.Hash),
+				fingerprint: r.Fingerprint,
+				hash:        r.Hash,
+				offset:      r.Offset,
+				keySize:     r.KeySize,
+				valueSize:   r.ValueSize,
+			})
+			if int64(len(entries)) == capacity {
+				if err := b.spill(entries); err != nil {
+					b.fail(err)
+					break
+				}
+				entries = entries[:0]
+			}
+		}
+	}
+	if len(entries) > 0 && !b.failed() {
+		if err := b.spill(entries); err != nil {
+			b.fail(err)
+		}
+	}
+}
+
+// spill sorts entries and writes them to a new run file.
+func (b *builder) spill(entries []runEntry) error {
+	sort.Slice(entries, func(x, y int) bool { return entries[x].less(entries[y]) })
+	f, err := ioutil.TempFile(b.runDir, "run")
+	if err != nil {
+		return fmt.Errorf("create run: %w", err)
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, RUN_BUFFER_SIZE)
+	buf := make([]byte, RUN_ENTRY_SIZE)
+	for _, e := range entries {
+		binary.LittleEndian.PutUint32(buf, e.chunkId)
+		binary.LittleEndian.PutUint32(buf[4:], e.fingerprint)
+		binary.LittleEndian.PutUint64(buf[8:], e.hash)
+		binary.LittleEndian.PutUint64(buf[16:], e.offset)
+		binary.LittleEndian.PutUint32(buf[24:], e.keySize)
+		binary.LittleEndian.PutUint32(buf[28:], e.valueSize)
+		if _, err = bw.Write(buf); err != nil {
+			return fmt.Errorf("write run %v: %w", f.Name(), err)
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return fmt.Errorf("write run %v: %w", f.Name(), err)
+	}
+	b.mutex.Lock()
+	b.runs = append(b.runs, f.Name())
+	b.mutex.Unlock()
+	return nil
+}
+
+// runReader streams the entries of one run, head being the current one.
+type runReader struct {
+	file *os.File
+	r    *bufio.Reader
+	buf  []byte
+	head runEntry
+}
+
+func openRun(name string) (*runReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open run: %w", err)
+	}
+	return &runReader{
+		file: f,
+		r:    bufio.NewReaderSize(f, RUN_BUFFER_SIZE),
+		buf:  make([]byte, RUN_ENTRY_SIZE),
+	}, nil
+}
+
+// next loads the following entry into head and reports whether there was one.
+func (rr *runReader) next() (bool, error) {
+	if _, err := io.ReadFull(rr.r, rr.buf); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read run %v: %w", rr.file.Name(), err)
+	}
+	rr.head = runEntry{
+		chunkId:     binary.LittleEndian.Uint32(rr.buf),
+		fingerprint: binary.LittleEndian.Uint32(rr.buf[4:]),
+		hash:        binary.LittleEndian.Uint64(rr.buf[8:]),
+		offset:      binary.LittleEndian.Uint64(rr.buf[16:]),
+		keySize:     binary.LittleEndian.Uint32(rr.buf[24:]),
+		valueSize:   binary.LittleEndian.Uint32(rr.buf[28:]),
+	}
+	return true, nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(x, y int) bool  { return h[x].head.less(h[y].head) }
+func (h runHeap) Swap(x, y int)       { h[x], h[y] = h[y], h[x] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	rr := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return rr
+}
+
+// merge k-way merges the runs straight into sealed chunks.
+func (b *builder) merge() error {
+	h := make(runHeap, 0, len(b.runs))
+	defer func() {
+		for _, rr := range h {
+			_ = rr.file.Close()
+		}
+	}()
+	for _, name := range b.runs {
+		rr, err := openRun(name)
+		if err != nil {
+			return err
+		}
+		ok, err := rr.next()
+		if !ok {
+			_ = rr.file.Close()
+		} else {
+			h = append(h, rr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	heap.Init(&h)
+
+	var w *chunk.Writer
+	var chunkId uint32
+	for h.Len() > 0 {
+		rr := h[0]
+		if w == nil || rr.head.chunkId != chunkId {
+			if w != nil {
+				if err := b.finish(chunkId, w); err != nil {
+					return err
+				}
+			}
+			var err error
+			chunkId = rr.head.chunkId
+			if w, err = chunk.NewWriter(b.index.opts.IndexDir, int(chunkId)); err != nil {
+				return fmt.Errorf("create chunk %v: %w", chunkId, err)
+			}
+		}
+		if err := w.Write(rr.head.record()); err != nil {
+			w.Abort()
+			return fmt.Errorf("write chunk %v: %w", chunkId, err)
+		}
+		ok, err := rr.next()
+		if err != nil {
+			w.Abort()
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			_ = rr.file.Close()
+			heap.Pop(&h)
+		}
+	}
+	if w != nil {
+		return b.finish(chunkId, w)
+	}
+	return nil
+}
+
+// finish closes the writer of a merged chunk and registers the chunk.
+func (b *builder) finish(chunkId uint32, w *chunk.Writer) error {
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("seal chunk %v: %w", chunkId, err)
+	}
+	c, err := chunk.New(b.index.opts.IndexDir, int(chunkId))
+	if err != nil {
+		return fmt.Errorf("open chunk %v: %w", chunkId, err)
+	}
+	if err = b.index.mapChunk(chunkId, &c); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err = b.index.addChunk(chunkId, &c); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/tabVersion/index-kv/bloom"
+	"github.com/tabVersion/index-kv/chunk"
+)
+
+const (
+	// FILTER_FILE holds the filters of every chunk, so that Open does not scan
+	// the chunks again to rebuild them.
+	FILTER_FILE  = "index_filters"
+	FILTER_MAGIC = "IKVFLT01"
+	// FILTER_MAX_BITS_PER_KEY caps the filters of an index much smaller than
+	// the budget, 16 bits per key already give about 0.1% false positives.
+	FILTER_MAX_BITS_PER_KEY = 16
+)
+
+// filterBitsPerKey shares opts.FilterBits evenly among the keys of the chunks:
+// it returns the most bits per key, up to FILTER_MAX_BITS_PER_KEY, whose
+// filters fit in the budget once rounded up to whole blocks. It returns 0 when
+// the filters are disabled or would get below a bit per key, where they would
+// let through most absent keys anyway.
+func (i *Index) filterBitsPerKey() float64 {
+	if i.opts.FilterBits <= 0 {
+		return 0
+	}
+	var keys int64
+	counts := make([]int, 0, len(i.chunkIds))
+	for id := range i.chunkIds {
+		counts = append(counts, int(i.findChunk(id).Count()))
+		keys += int64(counts[len(counts)-1])
+	}
+	for bitsPerKey := float64(FILTER_MAX_BITS_PER_KEY); bitsPerKey >= 1; bitsPerKey-- {
+		var bits int64
+		for _, n := range counts {
+			bits += int64(bloom.Size(n, bitsPerKey))
+		}
+		if bits <= i.opts.FilterBits {
+			return bitsPerKey
+		}
+	}
+	log.Printf("[index.filter.filterBitsPerKey] %v filter bits for %v keys in %v chunks, filters disabled\n",
+		i.opts.FilterBits, keys, len(counts))
+	return 0
+}
+
+// buildFilters scans the sealed chunks into one filter each, BuildWorkers
+// chunks at a time.
+func (i *Index) buildFilters(bitsPerKey float64) error {
+	filters := make([]*bloom.Filter, i.opts.ChunkNum)
+	ids := make(chan uint32)
+	errs := make(chan error, i.opts.BuildWorkers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < i.opts.BuildWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				c := i.findChunk(id)
+				f := bloom.New(int(c.Count()), bitsPerKey)
+				if err := c.Scan(func(r chunk.Record) { f.Add(r.Hash) }); err != nil {
+					errs <- fmt.Errorf("filter chunk %v: %w", id, err)
+					// let the other workers take the remaining chunks
+					for range ids {
+					}
+				}
+				filters[id] = f
+			}
+		}()
+	}
+	for id := range i.chunkIds {
+		ids <- id
+	}
+	close(ids)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	i.filters = filters
+	return nil
+}
+
+// saveFilters writes the filters as FILTER_MAGIC, the bits per key and the
+// filter count, then the chunk id, the size and the encoding of every filter.
+func (i *Index) saveFilters(bitsPerKey float64) error {
+	path := filepath.Join(i.opts.IndexDir, FILTER_FILE)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("create filter file: %w", err)
+	}
+	defer f.Close()
+	ids := make([]uint32, 0, len(i.chunkIds))
+	for id := range i.chunkIds {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	bw := bufio.NewWriter(f)
+	buf := make([]byte, 16)
+	_, _ = bw.WriteString(FILTER_MAGIC)
+	binary.LittleEndian.PutUint64(buf, math.Float64bits(bitsPerKey))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(len(ids)))
+	_, _ = bw.Write(buf)
+	for _, id := range ids {
+		content, _ := i.filters[id].MarshalBinary()
+		binary.LittleEndian.PutUint64(buf, uint64(id))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(len(content)))
+		_, _ = bw.Write(buf)
+		if _, err = bw.Write(content); err != nil {
+			return fmt.Errorf("write filter file: %w", err)
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return fmt.Errorf("write filter file: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadFilters reads the filters saved for bitsPerKey, and fails when they were
+// saved for another budget or another set of chunks.
+func (i *Index) loadFilters(bitsPerKey float64) error {
+	f, err := os.Open(filepath.Join(i.opts.IndexDir, FILTER_FILE))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, len(FILTER_MAGIC)+16)
+	if _, err = io.ReadFull(r, header); err != nil || string(header[:len(FILTER_MAGIC)]) != FILTER_MAGIC {
+		return fmt.Errorf("%w: bad filter file header, err: %v", bloom.ErrCorrupt, err)
+	}
+	saved := math.Float64frombits(binary.LittleEndian.Uint64(header[len(FILTER_MAGIC):]))
+	count := binary.LittleEndian.Uint64(header[len(FILTER_MAGIC)+8:])
+	if saved != bitsPerKey || count != uint64(len(i.chunkIds)) {
+		return fmt.Errorf("filters of %v chunks with %v bits per key, want %v chunks with %v",
+			count, saved, len(i.chunkIds), bitsPerKey)
+	}
+	filters := make([]*bloom.Filter, i.opts.ChunkNum)
+	buf := make([]byte, 16)
+	for n := uint64(0); n < count; n++ {
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("read filter file: %w", err)
+		}
+		id := binary.LittleEndian.Uint64(buf)
+		size := binary.LittleEndian.Uint64(buf[8:])
+		if _, exist := i.chunkIds[uint32(id)]; id >= uint64(len(filters)) || !exist {
+			return fmt.Errorf("%w: filter of unknown chunk %v", bloom.ErrCorrupt, id)
+		}
+		content := make([]byte, size)
+		if _, err = io.ReadFull(r, content); err != nil {
+			return fmt.Errorf("read filter file: %w", err)
+		}
+		filters[id] = new(bloom.Filter)
+		if err = filters[id].UnmarshalBinary(content); err != nil {
+			return err
+		}
+	}
+	i.filters = filters
+	return nil
+}
+
+// initFilters sets up the filters of a freshly built or loaded index. Filters
+// only speed up lookups, so failures are logged and leave them off.
+func (i *Index) initFilters(reuse bool) {
+	bitsPerKey := i.filterBitsPerKey()
+	if bitsPerKey == 0 {
+		return
+	}
+	if reuse {
+		err := i.loadFilters(bitsPerKey)
+		if err == nil {
+			return
+		}
+		log.Printf("[index.filter.initFilters] cannot reuse filters: %v, rebuilding\n", err)
+	}
+	if err := i.buildFilters(bitsPerKey); err != nil {
+		log.Printf("[index.filter.initFilters] build filters err: %v\n", err)
+		return
+	}
+	if err := i.saveFilters(bitsPerKey); err != nil {
+		log.Printf("[index.filter.initFilters] save filters err: %v\n", err)
+	}
+}
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+)
+
+// Hasher maps a key to the 64-bit hash stored in the chunks. The hash picks
+// the chunk of the key and tells its records apart within the chunk, so two
+// keys only share a record hash on a true 64-bit collision.
+type Hasher interface {
+	// Name identifies the function in the manifest.
+	Name() string
+	Sum64(key []byte) uint64
+}
+
+// HASH_PROBE is hashed into the manifest along with the hasher name, so that
+// a keyed hasher opened with another key is told apart from the one that
+// built the index.
+const HASH_PROBE = "index-kv hash probe"
+
+// XXHash64 returns the 64-bit xxHash with seed 0, the default Hasher.
+func XXHash64() Hasher {
+	return xxHash64{}
+}
+
+// FNV1a64 returns the 64-bit FNV-1a hash.
+func FNV1a64() Hasher {
+	return fnv1a64{}
+}
+
+// SipHash24 returns SipHash-2-4 keyed with key. Unlike the unkeyed hashers,
+// its collisions cannot be precomputed by whoever chooses the keys.
+func SipHash24(key [16]byte) Hasher {
+	return sipHash24{
+		k0: binary.LittleEndian.Uint64(key[:8]),
+		k1: binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// fingerprint is the second hash of a key stored in its records. CRC-32C has
+// nothing in common with any Hasher, so keys sharing a hash almost never
+// share a fingerprint, and a lookup reads only the records of its own key.
+func fingerprint(key []byte) uint32 {
+	return crc32.Checksum(key, castagnoli)
+}
+
+// hashCheck is the value recorded in the manifest for h.
+func hashCheck(h Hasher) uint64 {
+	return h.Sum64([]byte(HASH_PROBE))
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+type xxHash64 struct{}
+
+func (xxHash64) Name() string {
+	return "xxhash64"
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMerge(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+func (xxHash64) Sum64(key []byte) uint64 {
+	n := len(key)
+	var h uint64
+	if n >= 32 {
+		// v1 = prime1 + prime2 and v4 = -prime1, wrapping around
+		v1, v2, v3, v4 := xxPrime1, xxPrime2, uint64(0), uint64(0)
+		v1 += xxPrime2
+		v4 -= xxPrime1
+		for ; len(key) >= 32; key = key[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(key))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(key[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(key[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(key[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(key) >= 8; key = key[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(key))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(key) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(key)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		key = key[4:]
+	}
+	for _, c := range key {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+type fnv1a64 struct{}
+
+func (fnv1a64) Name() string {
+	return "fnv1a64"
+}
+
+func (fnv1a64) Sum64(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+type sipHash24 struct {
+	k0, k1 uint64
+}
+
+func (sipHash24) Name() string {
+	return "siphash-2-4"
+}
+
+func (s sipHash24) Sum64(key []byte) uint64 {
+	v0 := s.k0 ^ 0x736f6d6570736575
+	v1 := s.k1 ^ 0x646f72616e646f6d
+	v2 := s.k0 ^ 0x6c7967656e657261
+	v3 := s.k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(key)
+	for ; len(key) >= 8; key = key[8:] {
+		m := binary.LittleEndian.Uint64(key)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	last := uint64(n) << 56
+	for i, c := range key {
+		last |= uint64(c) << (8 * uint(i))
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
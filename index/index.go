@@ -1,153 +1,349 @@
 package index
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/tabVersion/index-kv/bloom"
 	"github.com/tabVersion/index-kv/cache"
 	"github.com/tabVersion/index-kv/chunk"
 	"github.com/tabVersion/index-kv/splay"
 	"log"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Index struct {
-	LRUCache  *cache.Cache
-	SplayRoot *splay.Tree
+	// counters come first to stay 64-bit aligned for atomic updates
+	counters counters
+
+	cache cache.Cache
+	// negative caches the keys that are not in the data file, nil when
+	// disabled
+	negative  cache.Cache
+	SplayRoot *splay.Tree[uint32, *chunk.Chunk]
+	// splayChunks guards SplayRoot for concurrent lookups, SplayRoot must not
+	// be used directly while they go on
+	splayChunks *splay.Concurrent[uint32, *chunk.Chunk]
 
-	splayMutex sync.Mutex
 	chunkMap   map[uint32]*chunk.Chunk
 	//lruMutex sync.RWMutex
 	queryAns    map[int32]string
 	queryMutex sync.Mutex
-	chunkMutex map[uint32]*sync.Mutex
+	// chunkIds is the set of chunks, whatever the backend holding them
+	chunkIds map[uint32]struct{}
+	// data is the data file, read only with ReadAt so that lookups share it
+	data *os.File
 	useLru      bool
 	useSplay    bool
 	routinePool chan struct{}
+	opts        Options
+	mph         *mphIndex
+	// filters of the chunks by chunk id, nil when disabled
+	filters []*bloom.Filter
+
+	// BuildTime is how long New took to build the index, zero when the index
+	// was reused by Open.
+	BuildTime time.Duration
 }
 
-func New(useLru bool, useSplay bool) Index {
-	var lruCache *cache.Cache = nil
-	var key []byte
-	if useLru {
-		lruCache, _ = cache.New(CACHE_SIZE)
+// New builds the index of opts.DataFile from scratch, replacing any index
+// previously built in opts.IndexDir.
+func New(opts Options) (_ *Index, err error) {
+	opts, err = opts.normalize()
+	if err != nil {
+		return nil, err
 	}
-	var splayRoot *splay.Tree = nil
-	var chunkMap map[uint32]*chunk.Chunk = nil
-	if useSplay {
-		splayRoot = new(splay.Tree)
-	} else {
-		chunkMap = make(map[uint32]*chunk.Chunk)
+	i, err := newIndex(opts)
+	if err != nil {
+		return nil, err
 	}
-	chunkMutex := make(map[uint32]*sync.Mutex)
-	queryAns := make(map[int32]string)
-	routinePool := make(chan struct{}, MAX_ROUTINE_LIMIT)
-	wg := sync.WaitGroup{}
+	defer func() {
+		if err != nil {
+			// drop the chunks built so far so that Open cannot reuse them
+			_ = i.closeFiles()
+			removeIndex(opts)
+		}
+	}()
 
 	// ===== preprocess =====
 	log.Printf("=====create index=====")
-	buildMutex := sync.Mutex{}
-	dataSource, err := os.OpenFile(DATAFILE, os.O_RDONLY|os.O_CREATE, 0777)
-	if err != nil {
-		log.Fatalf("[index.index.New] open data source %v err: %v\n", DATAFILE, err)
+	if err = os.MkdirAll(opts.IndexDir, 0777); err != nil {
+		return nil, fmt.Errorf("create index dir %v: %w", opts.IndexDir, err)
 	}
-	dataStat, err := dataSource.Stat()
-	if err != nil {
-		log.Fatalf("[index.index.New] load data source stat err: %v\n", err)
+	removeIndex(opts)
+	start := time.Now()
+	if err = i.build(); err != nil {
+		return nil, err
+	}
+	if i.mph == nil {
+		i.initFilters(false)
+	}
+	i.BuildTime = time.Since(start)
+	log.Printf("=====index built in %v with %v readers and %v workers=====", i.BuildTime, opts.BuildReaders, opts.BuildWorkers)
+	if err = i.openData(); err != nil {
+		return nil, err
 	}
+	if err = saveManifest(opts, i.chunkIds); err != nil {
+		log.Printf("[index.index.New] save manifest err: %v\n", err)
+	}
+	return i, nil
+}
 
-	curPos, err := dataSource.Seek(0, 1)
+// openData opens the data file shared by every lookup.
+func (i *Index) openData() (err error) {
+	i.data, err = os.Open(i.opts.DataFile)
 	if err != nil {
-		log.Fatalf("[index.index.New] load data source pos err: %v\n", err)
+		return fmt.Errorf("open data file %v: %w", i.opts.DataFile, err)
 	}
+	return nil
+}
 
-	for curPos < dataStat.Size() {
-		localPos := curPos
-		_, key, err = GetSizeAndContent(dataSource)
-		if err != nil {
-			log.Fatalf("[index.index.New] GetSizeAndContent load key err: %v\n", err)
+// Close saves the shape of the splay tree and releases the files of the
+// index. The index cannot be used afterwards.
+func (i *Index) Close() error {
+	if i.useSplay && len(i.chunkIds) > 0 {
+		// the shape only speeds up the lookups after Open, so failing to save
+		// it does not fail Close
+		if err := i.saveSplay(); err != nil {
+			log.Printf("[index.index.Close] save splay tree err: %v\n", err)
 		}
-		_, _, err = GetSizeAndContent(dataSource)
-		if err != nil {
-			log.Fatalf("[index.index.New] GetSizeAndContent load value err: %v\n", err)
+	}
+	return i.closeFiles()
+}
+
+// closeFiles releases the files of the index. Unlike Close it saves nothing,
+// so that an index failing to load, with only some of its chunks, does not
+// overwrite the saved shape of the splay tree with its own.
+func (i *Index) closeFiles() error {
+	var err error
+	keep := func(e error) {
+		if err == nil {
+			err = e
 		}
-		keyHash := Hash(key)
+	}
+	if i.data != nil {
+		keep(i.data.Close())
+	}
+	if i.mph != nil {
+		keep(i.mph.slots.Close())
+	}
+	for id := range i.chunkIds {
+		keep(i.findChunk(id).Close())
+	}
+	return err
+}
 
-		routinePool <- struct{}{}
-		wg.Add(1)
-		go func(chunkId uint32, keyHash uint32, offset int64, wg *sync.WaitGroup) {
-			defer func(wg *sync.WaitGroup) {
-				<-routinePool
-				wg.Done()
-			}(wg)
-			buildMutex.Lock()
-			cm, exist := chunkMutex[chunkId]
-			if !exist {
-				cm = &sync.Mutex{}
-				chunkMutex[chunkId] = cm
-			}
-			buildMutex.Unlock()
-			// =====
-			cm.Lock()
-			buildMutex.Lock()
-			var dataChunk *chunk.Chunk = nil
-			if useSplay {
-				dataNode := splay.FindNode(splayRoot, chunkId, splayRoot.GetRoot())
-				if dataNode != nil {
-					dataChunk = dataNode.Value
-				} else {
-					c, err := chunk.New(int(chunkId))
-					if err != nil {
-						log.Fatalf("[index.index.New] create chunk fail idx: %v, err: %v\n", chunkId, err)
-					}
-					dataChunk = &c
-					err = splay.Insert(splayRoot, chunkId, dataChunk)
-					if err != nil {
-						log.Fatalf("[index.index.New] splay insert chunk: %v, val: %v, err: %v\n",
-							chunkId, dataChunk, err)
-					}
-				}
-			} else {
-				c, exist := chunkMap[chunkId]
-				if !exist {
-					c, _ := chunk.New(int(chunkId))
-					dataChunk = &c
-					chunkMap[chunkId] = dataChunk
-				} else {
-					dataChunk = c
-				}
-			}
-			buildMutex.Unlock()
+// newCache returns the value cache of opts.CachePolicy, split into
+// opts.CacheShards shards.
+func newCache(opts Options) (cache.Cache, error) {
+	newShard := func(capacity int64) (cache.Cache, error) {
+		switch opts.CachePolicy {
+		case CacheTinyLFU:
+			return cache.NewTinyLFU(capacity)
+		case Cache2Q:
+			return cache.New2Q(capacity)
+		case CacheARC:
+			return cache.NewARC(capacity)
+		case CacheClock:
+			return cache.NewClock(capacity)
+		}
+		return cache.NewLRU(capacity)
+	}
+	if opts.CacheShards == 1 {
+		return newShard(opts.CacheSize)
+	}
+	return cache.NewSharded(opts.CacheSize, opts.CacheShards, newShard)
+}
 
-			err = dataChunk.Append(keyHash, uint64(offset))
-			if err != nil {
-				log.Fatalf("[index.index.New] chunk append key: %v, value: %v ,err: %v\n",
-					keyHash, offset, err)
-			}
-			//_ = dataChunk.Close()
-			cm.Unlock()
-		}(keyHash%CHUNK_NUM, keyHash, localPos, &wg)
-		wg.Wait()
-		curPos, err = dataSource.Seek(0, 1)
+// newNegativeCache returns an LRU cache of absent keys, split into
+// opts.CacheShards shards, whose keys expire after opts.NegativeCacheTTL.
+func newNegativeCache(opts Options) (cache.Cache, error) {
+	var negative cache.Cache
+	var err error
+	if opts.CacheShards == 1 {
+		negative, err = cache.NewLRU(opts.NegativeCacheSize)
+	} else {
+		negative, err = cache.NewSharded(opts.NegativeCacheSize, opts.CacheShards,
+			func(capacity int64) (cache.Cache, error) { return cache.NewLRU(capacity) })
+	}
+	if err != nil || opts.NegativeCacheTTL == 0 {
+		return negative, err
+	}
+	return cache.NewExpiring(negative, opts.NegativeCacheTTL), nil
+}
+
+// newIndex returns an index without any chunk.
+func newIndex(opts Options) (*Index, error) {
+	var err error
+	useLru := opts.CacheSize > 0
+	useSplay := opts.Backend == BackendSplay
+	var valueCache cache.Cache = nil
+	if useLru {
+		valueCache, err = newCache(opts)
 		if err != nil {
-			log.Fatalf("[index.index.New] load data source pos err: %v\n", err)
+			return nil, err
 		}
 	}
-	return Index{
-		LRUCache:    lruCache,
+	var negative cache.Cache = nil
+	if opts.NegativeCacheSize > 0 {
+		negative, err = newNegativeCache(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var splayRoot *splay.Tree[uint32, *chunk.Chunk] = nil
+	var splayChunks *splay.Concurrent[uint32, *chunk.Chunk] = nil
+	var chunkMap map[uint32]*chunk.Chunk = nil
+	if useSplay {
+		splayRoot = splay.New[uint32, *chunk.Chunk]()
+		splayChunks = splay.NewConcurrent(splayRoot, opts.SplayPeriod)
+	} else if opts.Backend == BackendMap {
+		chunkMap = make(map[uint32]*chunk.Chunk)
+	}
+	return &Index{
+		cache:       valueCache,
+		negative:    negative,
 		SplayRoot:   splayRoot,
-		splayMutex:  sync.Mutex{},
+		splayChunks: splayChunks,
 		chunkMap:    chunkMap,
-		chunkMutex: chunkMutex,
-		queryAns:    queryAns,
-		queryMutex: sync.Mutex{},
+		chunkIds:    make(map[uint32]struct{}),
+		queryAns:    make(map[int32]string),
 		useLru:      useLru,
 		useSplay:    useSplay,
-		routinePool: routinePool,
+		routinePool: make(chan struct{}, opts.MaxRoutines),
+		opts:        opts,
+	}, nil
+}
+
+// addChunk registers chunk c under id.
+func (i *Index) addChunk(id uint32, c *chunk.Chunk) error {
+	if i.useSplay {
+		if err := i.splayChunks.Insert(id, c); err != nil {
+			return fmt.Errorf("splay insert chunk %v: %w", id, err)
+		}
+	} else {
+		i.chunkMap[id] = c
+	}
+	i.chunkIds[id] = struct{}{}
+	return nil
+}
+
+// mapChunk maps the sealed chunk c into memory when opts.Mmap is set.
+func (i *Index) mapChunk(id uint32, c *chunk.Chunk) error {
+	if !i.opts.Mmap {
+		return nil
+	}
+	if err := c.Mmap(); err != nil {
+		return fmt.Errorf("map chunk %v: %w", id, err)
+	}
+	return nil
+}
+
+// findChunk returns the chunk registered under id, or nil. Unlike Get it does
+// not restructure the splay tree.
+func (i *Index) findChunk(id uint32) *chunk.Chunk {
+	if i.useSplay {
+		c, _ := i.splayChunks.Find(id)
+		return c
+	}
+	return i.chunkMap[id]
+}
+
+// Open reuses the index left in opts.IndexDir by a previous New when its
+// manifest still matches opts.DataFile, and rebuilds it otherwise. It fails
+// with ErrHashMismatch rather than replace an index built with another Hasher.
+func Open(opts Options) (*Index, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	cur, err := newManifest(opts)
+	if err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(opts.IndexDir)
+	if err == nil {
+		err = m.check(opts.IndexDir, cur)
+	}
+	if errors.Is(err, ErrHashMismatch) {
+		return nil, err
+	}
+	if err == nil {
+		idx, err := load(opts, m)
+		if err == nil {
+			log.Printf("=====reuse index=====")
+			return idx, nil
+		}
+	}
+	log.Printf("[index.index.Open] cannot reuse index: %v, rebuilding\n", err)
+	return New(opts)
+}
+
+// load opens the chunks listed by m without touching the data file.
+func load(opts Options, m *manifest) (_ *Index, err error) {
+	i, err := newIndex(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = i.closeFiles()
+		}
+	}()
+	if err = i.openData(); err != nil {
+		return nil, err
+	}
+	if opts.Backend == BackendMPH {
+		i.mph, err = loadMPH(opts.IndexDir, opts.Hasher)
+		if err != nil {
+			return nil, err
+		}
+		return i, nil
 	}
+	for _, id := range m.Chunks {
+		c, err := chunk.New(opts.IndexDir, int(id))
+		if err != nil {
+			return nil, fmt.Errorf("open chunk %v: %w", id, err)
+		}
+		if !c.Sealed() {
+			_ = c.Close()
+			return nil, fmt.Errorf("chunk %v is not sealed", id)
+		}
+		if err = i.mapChunk(id, &c); err == nil {
+			err = i.addChunk(id, &c)
+		}
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	i.initSplay()
+	i.initFilters(true)
+	return i, nil
+}
+
+// saveManifest records the chunks created by a finished build.
+func saveManifest(opts Options, chunkIds map[uint32]struct{}) error {
+	m, err := newManifest(opts)
+	if err != nil {
+		return err
+	}
+	m.Chunks = make([]uint32, 0, len(chunkIds))
+	for id := range chunkIds {
+		m.Chunks = append(m.Chunks, id)
+	}
+	sort.Slice(m.Chunks, func(a, b int) bool { return m.Chunks[a] < m.Chunks[b] })
+	return m.save(opts.IndexDir)
 }
 
 func (i *Index) Query(keys []string, startIdx int32) {
-	i.routinePool = make(chan struct{}, MAX_ROUTINE_LIMIT)
+	i.routinePool = make(chan struct{}, i.opts.MaxRoutines)
 	wg := sync.WaitGroup{}
 	for idx, key := range keys {
 		i.routinePool <- struct{}{}
@@ -163,105 +359,196 @@ func (i *Index) Index(key string, idx int32, wg *sync.WaitGroup) (err error) {
 		wg.Done()
 	}(wg)
 
+	value, err := i.Get(context.Background(), []byte(key))
+	i.queryMutex.Lock()
+	i.queryAns[idx] = string(value)
+	i.queryMutex.Unlock()
+	return err
+}
+
+// Get returns the value stored for key. The error wraps ErrNotFound when the
+// key is not in the data file, so callers can tell a miss from a failure with
+// errors.Is. With the cache enabled, the value is shared with the cache and
+// must not be modified.
+func (i *Index) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if len(key) < i.opts.MinKeySize {
+		return nil, ErrEmptyKey
+	}
+	if len(key) > i.opts.MaxKeySize {
+		return nil, fmt.Errorf("%w: %v bytes", ErrKeyTooLarge, len(key))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if i.useLru {
 		//i.lruMutex.RLock()
-		vCache, success := i.LRUCache.Get(key)
+		vCache, success := i.cache.Get(key)
 		//i.lruMutex.RUnlock()
 		if success {
-			log.Printf("[index.index.Index] cache hit key: %v, value: %v\n", key, vCache)
-			i.queryMutex.Lock()
-			i.queryAns[idx] = vCache
-			i.queryMutex.Unlock()
-			return nil
+			return vCache, nil
 		}
 	}
-	keyHash := Hash([]byte(key))
-	var dataChunk *chunk.Chunk
-	if i.useSplay {
-		i.splayMutex.Lock()
-		dataNode := splay.Access(i.SplayRoot, keyHash % CHUNK_NUM)
-		i.splayMutex.Unlock()
-		if dataNode == nil {
-			log.Printf("[index.index.Index] cannot find chunk %d for key %v", keyHash, key)
-			i.queryMutex.Lock()
-			i.queryAns[idx] = ""
-			i.queryMutex.Unlock()
-			return errors.New("not found: key: " + key)
+	if i.negative != nil {
+		if _, absent := i.negative.Get(key); absent {
+			return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
 		}
-		dataChunk = dataNode.Value
-	} else {
-		dataChunk = i.chunkMap[keyHash % CHUNK_NUM]
-	}
-	cm, exist := i.chunkMutex[keyHash % CHUNK_NUM]
-	if !exist {
-		log.Fatalf("[index.index.Index] chunkMutex not found, chunk: %v", keyHash % CHUNK_NUM)
-	}
-	cm.Lock()
-	offsets, err := dataChunk.Index(keyHash)
-	cm.Unlock()
-	if err != nil {
-		log.Printf("[chunk.index.Index] offset not found: key: %v, chunk: %v, err: %v\n",
-			key, keyHash % CHUNK_NUM, err)
-		i.queryMutex.Lock()
-		i.queryAns[idx] = ""
-		i.queryMutex.Unlock()
-		return err
 	}
-
-	allData, err := os.OpenFile(DATAFILE, os.O_RDONLY|os.O_CREATE, 0777)
+	atomic.AddInt64(&i.counters.lookups, 1)
+	records, err := i.locate(key)
 	if err != nil {
-		log.Printf("[chunk.index.Index] open all data file err: %v\n", err)
-		i.queryMutex.Lock()
-		i.queryAns[idx] = ""
-		i.queryMutex.Unlock()
-		return err
+		if errors.Is(err, ErrNotFound) {
+			i.notFound(key)
+		}
+		return nil, err
 	}
-	defer allData.Close()
 
-	for _, offset := range offsets {
-		_, _ = allData.Seek(int64(offset), 0)
-		readKeySize, readKey, err := GetSizeAndContent(allData)
-		if err != nil {
-			log.Printf("[index.index.Index] get content key err: %v\n", err)
-			i.queryMutex.Lock()
-			i.queryAns[idx] = ""
-			i.queryMutex.Unlock()
-			return err
-		}
-		if readKeySize < MIN_KEY_SIZE || readKeySize > MAX_KEY_SIZE {
-			log.Printf("[index.index.Index] key size error: %v\n", readKeySize)
-			i.queryMutex.Lock()
-			i.queryAns[idx] = ""
-			i.queryMutex.Unlock()
-			return errors.New("key size error")
+	for _, r := range records {
+		if err = ctx.Err(); err != nil {
+			return nil, err
 		}
-		readValueSize, readValue, err := GetSizeAndContent(allData)
+		readValue, match, err := i.read(key, r)
 		if err != nil {
-			log.Printf("[index.index.Index] get content value err: %v\n", err)
-			i.queryMutex.Lock()
-			i.queryAns[idx] = ""
-			i.queryMutex.Unlock()
-			return err
+			log.Printf("[index.index.Get] read record err: %v\n", err)
+			return nil, err
 		}
-		if readValueSize < MIN_VALUE_SIZE || readValueSize > MAX_VALUE_SIZE {
-			log.Printf("[index.index.Index] value size error: %v\n", readKeySize)
-			i.queryMutex.Lock()
-			i.queryAns[idx] = ""
-			i.queryMutex.Unlock()
-			return errors.New("value size error")
-		}
-
-		if string(readKey) == key {
-			i.queryMutex.Lock()
-			i.queryAns[idx] = string(readValue)
-			i.queryMutex.Unlock()
+		if match {
 			if i.useLru {
 				//i.lruMutex.Lock()
-				i.LRUCache.Add(key, string(readValue))
+				i.cache.Add(key, readValue)
 				//i.lruMutex.Unlock()
 			}
-			return nil
+			return readValue, nil
 		}
+		atomic.AddInt64(&i.counters.collisions, 1)
+	}
+	i.notFound(key)
+	return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
+}
+
+// notFound records a lookup of key that is not in the data file.
+func (i *Index) notFound(key []byte) {
+	atomic.AddInt64(&i.counters.notFound, 1)
+	if i.negative != nil {
+		i.negative.Add(key, nil)
 	}
-	return errors.New("key %v not found")
+}
+
+// chunkOf returns the chunk holding the records of keyHash.
+func (i *Index) chunkOf(keyHash uint64) uint32 {
+	return uint32(keyHash % uint64(i.opts.ChunkNum))
+}
+
+// read fetches the record r of the data file with a single ReadAt, and
+// returns its value if its key is key. When opts.TrustFingerprint is set the
+// key is not read at all and the value is returned as is.
+func (i *Index) read(key []byte, r chunk.Record) (value []byte, match bool, err error) {
+	atomic.AddInt64(&i.counters.dataReads, 1)
+	if i.opts.TrustFingerprint {
+		value = make([]byte, r.ValueSize)
+		if _, err = i.data.ReadAt(value, int64(r.Offset)+16+int64(r.KeySize)); err != nil {
+			return nil, false, fmt.Errorf("%w: offset %v: %v", ErrCorruptRecord, r.Offset, err)
+		}
+		return value, true, nil
+	}
+	buf := make([]byte, 16+int(r.KeySize)+int(r.ValueSize))
+	if _, err = i.data.ReadAt(buf, int64(r.Offset)); err != nil {
+		return nil, false, fmt.Errorf("%w: offset %v: %v", ErrCorruptRecord, r.Offset, err)
+	}
+	keySize, err := binary.ReadUvarint(bytes.NewBuffer(buf[:8]))
+	if err != nil || keySize != uint64(r.KeySize) {
+		return nil, false, fmt.Errorf("%w: offset %v: key size %v, indexed %v, err: %v",
+			ErrCorruptRecord, r.Offset, keySize, r.KeySize, err)
+	}
+	valueSize, err := binary.ReadUvarint(bytes.NewBuffer(buf[8+keySize : 16+keySize]))
+	if err != nil || valueSize != uint64(r.ValueSize) {
+		return nil, false, fmt.Errorf("%w: offset %v: value size %v, indexed %v, err: %v",
+			ErrCorruptRecord, r.Offset, valueSize, r.ValueSize, err)
+	}
+	if !bytes.Equal(buf[8:8+keySize], key) {
+		return nil, false, nil
+	}
+	return buf[16+keySize:], true, nil
+}
+
+// locate returns the records of the data file whose key may be key: those of
+// its hash whose fingerprint matches.
+func (i *Index) locate(key []byte) ([]chunk.Record, error) {
+	var records []chunk.Record
+	var err error
+	if i.mph != nil {
+		records, err = i.mph.locate(key)
+	} else {
+		records, err = i.locateChunk(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&i.counters.candidates, int64(len(records)))
+	keyPrint := fingerprint(key)
+	matches := records[:0]
+	for _, r := range records {
+		if r.Fingerprint == keyPrint && int(r.KeySize) == len(key) {
+			matches = append(matches, r)
+		}
+	}
+	atomic.AddInt64(&i.counters.collisions, int64(len(records)-len(matches)))
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
+	}
+	return matches, nil
+}
+
+// locateChunk returns the records of the hash of key in its chunk.
+func (i *Index) locateChunk(key []byte) ([]chunk.Record, error) {
+	keyHash := i.opts.Hasher.Sum64(key)
+	chunkId := i.chunkOf(keyHash)
+	if i.filters != nil && (i.filters[chunkId] == nil || !i.filters[chunkId].MayContain(keyHash)) {
+		atomic.AddInt64(&i.counters.filterNegatives, 1)
+		return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
+	}
+	var dataChunk *chunk.Chunk
+	if i.useSplay {
+		c, exist := i.splayChunks.Get(chunkId)
+		if !exist {
+			return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
+		}
+		dataChunk = c
+	} else {
+		c, exist := i.chunkMap[chunkId]
+		if !exist {
+			return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
+		}
+		dataChunk = c
+	}
+	atomic.AddInt64(&i.counters.chunkScans, 1)
+	records, err := dataChunk.Index(keyHash)
+	if err != nil {
+		log.Printf("[index.index.locateChunk] offset not found: key: %s, chunk: %v, err: %v\n",
+			key, chunkId, err)
+		return nil, fmt.Errorf("index chunk %v: %w", chunkId, err)
+	}
+	return records, nil
+}
+
+// GetMany looks up keys concurrently. values[n] and errs[n] hold the result
+// for keys[n].
+func (i *Index) GetMany(ctx context.Context, keys [][]byte) (values [][]byte, errs []error) {
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+	routinePool := make(chan struct{}, i.opts.MaxRoutines)
+	wg := sync.WaitGroup{}
+	for idx, key := range keys {
+		routinePool <- struct{}{}
+		wg.Add(1)
+		go func(idx int, key []byte) {
+			defer func() {
+				<-routinePool
+				wg.Done()
+			}()
+			values[idx], errs[idx] = i.Get(ctx, key)
+		}(idx, key)
+	}
+	wg.Wait()
+	return values, errs
 }
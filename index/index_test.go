@@ -2,12 +2,19 @@ package index
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"github.com/tabVersion/index-kv/bloom"
 	"github.com/tabVersion/index-kv/chunk"
+	"github.com/tabVersion/index-kv/splay"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -32,19 +39,32 @@ func shuffle(mockKey, mockValue []string) ([]string, []string) {
 	return mockKey, mockValue
 }
 
-func genData() ([]string, []string) {
+func genData(path string) ([]string, []string) {
+	return genDataSizes(path, MIN_VALUE_SIZE, MAX_VALUE_SIZE)
+}
+
+// genDataSizes is genData with values of minValueSize to maxValueSize bytes.
+func genDataSizes(path string, minValueSize, maxValueSize int) ([]string, []string) {
 	mockKey := make([]string, 0)
 	mockValue := make([]string, 0)
-	dataFile, err := os.OpenFile(DATAFILE, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0777)
+	dataFile, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0777)
 	if err != nil {
 		log.Fatalf("[index.index_test.genData] open data file err: %v\n", err)
 	}
 	defer dataFile.Close()
+	// short keys are drawn again now and then, draw again so that every mock
+	// key has a single value
+	drawn := make(map[string]struct{})
 	for i := 0; i < NUM_KV; i++ {
 		keySize := seededRand.Intn(MAX_KEY_SIZE-MIN_KEY_SIZE) + MIN_KEY_SIZE
 		key := randomString(keySize)
+		if _, exist := drawn[string(key)]; exist {
+			i--
+			continue
+		}
+		drawn[string(key)] = struct{}{}
 		mockKey = append(mockKey, string(key))
-		valueSize := seededRand.Intn(MAX_VALUE_SIZE-MIN_VALUE_SIZE) + MIN_VALUE_SIZE
+		valueSize := seededRand.Intn(maxValueSize-minValueSize) + minValueSize
 		value := randomString(valueSize)
 		mockValue = append(mockValue, string(value))
 
@@ -65,12 +85,27 @@ func genData() ([]string, []string) {
 	return mockKey, mockValue
 }
 
+func testOptions(useLru bool, useSplay bool) Options {
+	opts := DefaultOptions()
+	if !useLru {
+		opts.CacheSize = 0
+	}
+	if useSplay {
+		opts.Backend = BackendSplay
+	}
+	return opts
+}
+
 func BenchmarkNew(b *testing.B) {
-	genData()
+	genData(DATAFILE)
 	log.Printf("[index.index_test.BenchmarkNew] genData done.")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		New(true, true)
+		idx, err := New(testOptions(true, true))
+		if err != nil {
+			log.Fatalf("[index.index_test.BenchmarkNew] create index err: %v\n", err)
+		}
+		_ = idx.Close()
 	}
 	err := os.Remove(DATAFILE)
 	if err != nil {
@@ -84,8 +119,71 @@ func BenchmarkNew(b *testing.B) {
 	}
 }
 
+func BenchmarkNew_Mode(b *testing.B) {
+	genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for _, mode := range []BuildMode{BuildAppend, BuildExternalSort} {
+		b.Run(mode.String(), func(b *testing.B) {
+			opts := testOptions(true, true)
+			opts.BuildMode = mode
+			for i := 0; i < b.N; i++ {
+				idx, err := New(opts)
+				if err != nil {
+					log.Fatalf("[index.index_test.BenchmarkNew_Mode] create index err: %v\n", err)
+				}
+				_ = idx.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkNew_Backend(b *testing.B) {
+	genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for _, backend := range []Backend{BackendMap, BackendMPH} {
+		b.Run(backend.String(), func(b *testing.B) {
+			opts := testOptions(true, false)
+			opts.Backend = backend
+			for i := 0; i < b.N; i++ {
+				idx, err := New(opts)
+				if err != nil {
+					log.Fatalf("[index.index_test.BenchmarkNew_Backend] create index err: %v\n", err)
+				}
+				_ = idx.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkNew_Workers(b *testing.B) {
+	genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			opts := testOptions(true, true)
+			opts.BuildWorkers = workers
+			for i := 0; i < b.N; i++ {
+				idx, err := New(opts)
+				if err != nil {
+					log.Fatalf("[index.index_test.BenchmarkNew_Workers] create index err: %v\n", err)
+				}
+				_ = idx.Close()
+			}
+		})
+	}
+}
+
 func TestIndex(t *testing.T) {
-	mockKey, mockValue := genData()
+	mockKey, mockValue := genData(DATAFILE)
 	for s := 0; s < 4; s ++ {
 		var useLru, useSplay bool
 		if s == 0 {
@@ -101,15 +199,19 @@ func TestIndex(t *testing.T) {
 			useLru = true
 			useSplay = true
 		}
-		idx := New(useLru, useSplay)
+		idx, err := New(testOptions(useLru, useSplay))
+		if err != nil {
+			log.Fatalf("[index.index_test.TestIndex] create index err: %v\n", err)
+		}
 		idx.Query(mockKey[:200], 0)
 		log.Printf("[index.index_test.TestIndex] evaluating")
 		for i, value := range mockValue[:200] {
 			if idx.queryAns[int32(i)] != value {
 				log.Fatalf("[index.index_test.TestIndex] query error: keyHash: %v, res: %v, truth: %v\n",
-					Hash([]byte(mockKey[i])), idx.queryAns[int32(i)], value)
+					idx.opts.Hasher.Sum64([]byte(mockKey[i])), idx.queryAns[int32(i)], value)
 			}
 		}
+		_ = idx.Close()
 		for i := 0; i < CHUNK_NUM; i++ {
 			_ = os.Remove(strconv.Itoa(i) + "_chunk")
 		}
@@ -120,6 +222,559 @@ func TestIndex(t *testing.T) {
 	}
 }
 
+func TestOpen(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	check := func(idx *Index) {
+		idx.Query(mockKey[:200], 0)
+		for i, value := range mockValue[:200] {
+			if idx.queryAns[int32(i)] != value {
+				log.Fatalf("[index.index_test.TestOpen] query error: key: %v, res: %v, truth: %v\n",
+					mockKey[i], idx.queryAns[int32(i)], value)
+			}
+		}
+	}
+
+	removeIndex(DefaultOptions())
+	idx, err := Open(testOptions(false, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOpen] build index err: %v\n", err)
+	}
+	defer idx.Close()
+	check(idx)
+	built, err := os.Stat(manifestPath("."))
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOpen] manifest not written: %v\n", err)
+	}
+
+	idx, err = Open(testOptions(false, true))
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOpen] reopen index err: %v\n", err)
+	}
+	defer idx.Close()
+	check(idx)
+	reused, _ := os.Stat(manifestPath("."))
+	if !reused.ModTime().Equal(built.ModTime()) {
+		log.Fatalf("[index.index_test.TestOpen] index rebuilt although data file is unchanged")
+	}
+
+	// a new data file makes the manifest stale
+	mockKey, mockValue = genData(DATAFILE)
+	idx, err = Open(testOptions(false, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOpen] rebuild index err: %v\n", err)
+	}
+	defer idx.Close()
+	check(idx)
+
+	// a rebuild with fewer chunks leaves none of the former ones
+	opts := testOptions(false, false)
+	opts.ChunkNum = 16
+	idx, err = New(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOpen] rebuild index err: %v\n", err)
+	}
+	defer idx.Close()
+	check(idx)
+	if chunks, _ := filepath.Glob("*_chunk"); len(chunks) > opts.ChunkNum {
+		log.Fatalf("[index.index_test.TestOpen] %v chunk files left for %v chunks\n", len(chunks), opts.ChunkNum)
+	}
+}
+
+func TestSplayShape(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	ctx := context.Background()
+	opts := testOptions(false, true)
+	opts.SplayPeriod = 1
+	removeIndex(opts)
+	idx, err := Open(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestSplayShape] build index err: %v\n", err)
+	}
+	for _, key := range mockKey[:10] {
+		if _, err = idx.Get(ctx, []byte(key)); err != nil {
+			log.Fatalf("[index.index_test.TestSplayShape] get key: %v, err: %v\n", key, err)
+		}
+	}
+	stats := idx.Stats().Tree
+	if stats.Len != len(idx.chunkIds) || stats.Searches < 10 || stats.AvgDepth < 1 || stats.Height < 1 {
+		log.Fatalf("[index.index_test.TestSplayShape] tree stats: %+v\n", stats)
+	}
+	var shape string
+	splay.Preorder(idx.SplayRoot.GetRoot(), &shape)
+	if err = idx.Close(); err != nil {
+		log.Fatalf("[index.index_test.TestSplayShape] close index err: %v\n", err)
+	}
+
+	// the chunks splayed up before Close are back near the root
+	idx, err = Open(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestSplayShape] reopen index err: %v\n", err)
+	}
+	var reopened string
+	splay.Preorder(idx.SplayRoot.GetRoot(), &reopened)
+	if reopened != shape {
+		log.Fatalf("[index.index_test.TestSplayShape] shape after reopen: %v, want: %v\n", reopened, shape)
+	}
+	for n, key := range mockKey[:100] {
+		if value, err := idx.Get(ctx, []byte(key)); err != nil || string(value) != mockValue[n] {
+			log.Fatalf("[index.index_test.TestSplayShape] get key: %v, res: %s, err: %v\n", key, value, err)
+		}
+	}
+	_ = idx.Close()
+
+	// a load failing on its last chunk, an empty one, keeps the saved shape
+	saved, _ := ioutil.ReadFile(filepath.Join(opts.IndexDir, SPLAY_FILE))
+	m, err := loadManifest(opts.IndexDir)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestSplayShape] load manifest err: %v\n", err)
+	}
+	missing := uint32(0)
+	for _, exist := idx.chunkIds[missing]; exist; _, exist = idx.chunkIds[missing] {
+		missing++
+	}
+	m.Chunks = append(m.Chunks, missing)
+	if _, err = load(opts, m); err == nil {
+		log.Fatalf("[index.index_test.TestSplayShape] load with an empty chunk succeeded\n")
+	}
+	if kept, _ := ioutil.ReadFile(filepath.Join(opts.IndexDir, SPLAY_FILE)); !bytes.Equal(kept, saved) {
+		log.Fatalf("[index.index_test.TestSplayShape] failed load overwrote the splay shape\n")
+	}
+	_ = os.Remove(chunk.Path(opts.IndexDir, int(missing)))
+
+	// a shape of other chunks is ignored
+	if err = ioutil.WriteFile(filepath.Join(opts.IndexDir, SPLAY_FILE), []byte(splay.MAGIC+"\x00"), 0666); err != nil {
+		log.Fatalf("[index.index_test.TestSplayShape] write splay file err: %v\n", err)
+	}
+	idx, err = Open(opts)
+	if err != nil || idx.SplayRoot.Len() != len(idx.chunkIds) {
+		log.Fatalf("[index.index_test.TestSplayShape] open with another shape err: %v\n", err)
+	}
+	_ = idx.Close()
+}
+
+func TestHasher(t *testing.T) {
+	var key [16]byte
+	message := make([]byte, 15)
+	for i := range key {
+		key[i] = byte(i)
+		if i < len(message) {
+			message[i] = byte(i)
+		}
+	}
+	// reference vectors of each function
+	vectors := []struct {
+		hasher Hasher
+		key    []byte
+		hash   uint64
+	}{
+		{XXHash64(), []byte(""), 0xef46db3751d8e999},
+		{XXHash64(), []byte("abc"), 0x44bc2cf5ad770999},
+		{XXHash64(), []byte("Nobody inspects the spammish repetition"), 0xfbcea83c8a378bf1},
+		{FNV1a64(), []byte(""), 0xcbf29ce484222325},
+		{FNV1a64(), []byte("a"), 0xaf63dc4c8601ec8c},
+		{SipHash24(key), []byte(""), 0x726fdb47dd0e0e31},
+		{SipHash24(key), message, 0xa129ca6149be45e5},
+	}
+	for _, v := range vectors {
+		if hash := v.hasher.Sum64(v.key); hash != v.hash {
+			log.Fatalf("[index.index_test.TestHasher] %v(%q): %x, want: %x\n", v.hasher.Name(), v.key, hash, v.hash)
+		}
+	}
+}
+
+func TestOpenHasher(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	var key [16]byte
+	copy(key[:], "0123456789abcdef")
+	opts := testOptions(false, false)
+	opts.Hasher = SipHash24(key)
+	removeIndex(opts)
+	idx, err := Open(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOpenHasher] build index err: %v\n", err)
+	}
+	defer idx.Close()
+	value, err := idx.Get(context.Background(), []byte(mockKey[0]))
+	if err != nil || string(value) != mockValue[0] {
+		log.Fatalf("[index.index_test.TestOpenHasher] get: %s, err: %v, truth: %v\n", value, err, mockValue[0])
+	}
+
+	for _, hasher := range []Hasher{XXHash64(), SipHash24([16]byte{})} {
+		opts.Hasher = hasher
+		if _, err = Open(opts); !errors.Is(err, ErrHashMismatch) {
+			log.Fatalf("[index.index_test.TestOpenHasher] open with %v err: %v, want: %v\n",
+				hasher.Name(), err, ErrHashMismatch)
+		}
+	}
+	opts.Hasher = SipHash24(key)
+	if idx, err = Open(opts); err != nil {
+		log.Fatalf("[index.index_test.TestOpenHasher] reopen index err: %v\n", err)
+	}
+	defer idx.Close()
+}
+
+func BenchmarkNew_Readers(b *testing.B) {
+	// values longer than the keys, so that the readers find the boundaries
+	maxValueSize := 4 * MAX_KEY_SIZE
+	genDataSizes(DATAFILE, MAX_KEY_SIZE+1, maxValueSize)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for _, readers := range []int{1, 2, 4, 8} {
+		b.Run("readers="+strconv.Itoa(readers), func(b *testing.B) {
+			opts := testOptions(true, true)
+			opts.BuildReaders = readers
+			opts.MaxValueSize = maxValueSize
+			for i := 0; i < b.N; i++ {
+				idx, err := New(opts)
+				if err != nil {
+					log.Fatalf("[index.index_test.BenchmarkNew_Readers] create index err: %v\n", err)
+				}
+				_ = idx.Close()
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	// values longer than the keys, so that the readers find the boundaries
+	maxValueSize := 4 * MAX_KEY_SIZE
+	mockKey, mockValue := genDataSizes(DATAFILE, MAX_KEY_SIZE+1, maxValueSize)
+	defer func(size int) {
+		buildSegmentSize = size
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}(buildSegmentSize)
+	buildSegmentSize = 1
+	for _, mode := range []BuildMode{BuildAppend, BuildExternalSort} {
+		for _, workers := range []int{1, 3, 8} {
+			opts := testOptions(false, workers%2 == 1)
+			opts.BuildWorkers = workers
+			opts.BuildReaders = workers
+			opts.BuildMode = mode
+			opts.MaxValueSize = maxValueSize
+			// a few dozen records per run
+			opts.BuildMemory = int64(workers) * 50 * RUN_ENTRY_SIZE
+			idx, err := New(opts)
+			if err != nil {
+				log.Fatalf("[index.index_test.TestBuild] create index err: %v\n", err)
+			}
+			if idx.BuildTime <= 0 {
+				log.Fatalf("[index.index_test.TestBuild] build time not reported")
+			}
+			for i, key := range mockKey {
+				value, err := idx.Get(context.Background(), []byte(key))
+				if err != nil || string(value) != mockValue[i] {
+					log.Fatalf("[index.index_test.TestBuild] mode: %v, workers: %v, key: %v, res: %s, err: %v\n",
+						mode, workers, key, value, err)
+				}
+			}
+			_ = idx.Close()
+		}
+	}
+	if dirs, _ := filepath.Glob("runs*"); len(dirs) > 0 {
+		log.Fatalf("[index.index_test.TestBuild] runs left behind: %v\n", dirs)
+	}
+
+	// a truncated data file is reported instead of indexed
+	data, _ := os.OpenFile(DATAFILE, os.O_WRONLY|os.O_APPEND, 0777)
+	_, _ = data.Write([]byte{8, 0, 0, 0, 0, 0, 0, 0, 'a'})
+	_ = data.Close()
+	opts := testOptions(false, false)
+	opts.BuildMode = BuildAppend
+	opts.BuildReaders = 3
+	opts.MaxValueSize = maxValueSize
+	if _, err := New(opts); !errors.Is(err, ErrCorruptRecord) {
+		log.Fatalf("[index.index_test.TestBuild] truncated data file err: %v\n", err)
+	}
+	// nor does the failed build leave chunks for Open to reuse
+	if chunks, _ := filepath.Glob("*_chunk"); len(chunks) > 0 {
+		log.Fatalf("[index.index_test.TestBuild] chunks left behind: %v\n", len(chunks))
+	}
+	if _, err := os.Stat(manifestPath(".")); !os.IsNotExist(err) {
+		log.Fatalf("[index.index_test.TestBuild] manifest left behind, err: %v\n", err)
+	}
+}
+
+func TestBoundaries(t *testing.T) {
+	defer os.Remove(DATAFILE)
+	// values as short as the keys parse as records too, so that the records
+	// of genData are found only from the start of the file, whereas values
+	// longer than the largest key leave a single parse after a few records
+	for _, long := range []bool{false, true} {
+		opts := DefaultOptions()
+		if long {
+			opts.MaxValueSize = 4 * MAX_KEY_SIZE
+			genDataSizes(DATAFILE, MAX_KEY_SIZE+1, opts.MaxValueSize)
+		} else {
+			genData(DATAFILE)
+		}
+		data, err := ioutil.ReadFile(DATAFILE)
+		if err != nil {
+			log.Fatalf("[index.index_test.TestBoundaries] read data file err: %v\n", err)
+		}
+		b := &builder{index: &Index{opts: opts}}
+		starts := make(map[int64]bool)
+		for pos := 0; pos < len(data); {
+			starts[int64(pos)] = true
+			_, size, _, _ := b.recordSize(data[pos:])
+			pos += size
+		}
+		size := int64(len(data))
+		for _, readers := range []int{1, 2, 8, 64} {
+			b.index.opts.BuildReaders = readers
+			bounds, err := b.boundaries(bytes.NewReader(data), size)
+			if err != nil {
+				log.Fatalf("[index.index_test.TestBoundaries] readers: %v, err: %v\n", readers, err)
+			}
+			if bounds[0] != 0 || bounds[len(bounds)-1] != size {
+				log.Fatalf("[index.index_test.TestBoundaries] readers: %v, bounds: %v\n", readers, bounds)
+			}
+			for r := 1; r < len(bounds)-1; r++ {
+				if !starts[bounds[r]] || bounds[r] <= bounds[r-1] {
+					log.Fatalf("[index.index_test.TestBoundaries] readers: %v, bound %v is no record boundary\n",
+						readers, bounds[r])
+				}
+			}
+			// the boundary after a cut may lie past the next one in small ranges
+			if long && readers <= 8 && len(bounds)-1 != readers {
+				log.Fatalf("[index.index_test.TestBoundaries] readers: %v, ranges: %v\n", readers, len(bounds)-1)
+			}
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	invalid := []Options{
+		{ChunkNum: -1},
+		{CacheSize: -1},
+		{CachePolicy: CachePolicy(42)},
+		{CacheShards: -1},
+		{SplayPeriod: -1},
+		{BuildReaders: -1},
+		{CacheSize: 16 << 10},
+		{CacheSize: 1 << 20, CacheShards: 1000},
+		{NegativeCacheSize: 1 << 10},
+		{NegativeCacheSize: -1},
+		{NegativeCacheTTL: -time.Second},
+		{MinKeySize: 10, MaxKeySize: 5},
+		{Backend: Backend(42)},
+	}
+	for _, opts := range invalid {
+		if _, err := Open(opts); !errors.Is(err, ErrInvalidOptions) {
+			log.Fatalf("[index.index_test.TestOptions] options: %+v, err: %v\n", opts, err)
+		}
+	}
+
+	// two datasets indexed side by side in one process
+	dir, err := ioutil.TempDir("", "index-kv")
+	if err != nil {
+		log.Fatalf("[index.index_test.TestOptions] create temp dir err: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	indexes := make([]*Index, 2)
+	keys := make([][]string, 2)
+	values := make([][]string, 2)
+	for n := range indexes {
+		opts := Options{
+			DataFile: filepath.Join(dir, "data"+strconv.Itoa(n)),
+			IndexDir: filepath.Join(dir, "index"+strconv.Itoa(n)),
+			ChunkNum: 16 * (n + 1),
+			Backend:  Backend(n),
+		}
+		keys[n], values[n] = genData(opts.DataFile)
+		indexes[n], err = Open(opts)
+		if err != nil {
+			log.Fatalf("[index.index_test.TestOptions] open index %v err: %v\n", n, err)
+		}
+		defer indexes[n].Close()
+	}
+	for n, idx := range indexes {
+		for i, key := range keys[n][:100] {
+			value, err := idx.Get(context.Background(), []byte(key))
+			if err != nil || string(value) != values[n][i] {
+				log.Fatalf("[index.index_test.TestOptions] index %v key: %v, res: %s, err: %v\n",
+					n, key, value, err)
+			}
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	idx, err := New(testOptions(true, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.TestGet] create index err: %v\n", err)
+	}
+	defer idx.Close()
+	ctx := context.Background()
+	for i, key := range mockKey[:200] {
+		value, err := idx.Get(ctx, []byte(key))
+		if err != nil || string(value) != mockValue[i] {
+			log.Fatalf("[index.index_test.TestGet] get key: %v, res: %s, err: %v, truth: %v\n",
+				key, value, err, mockValue[i])
+		}
+	}
+
+	if _, err := idx.Get(ctx, []byte("#not-a-key#")); !errors.Is(err, ErrNotFound) {
+		log.Fatalf("[index.index_test.TestGet] missing key err: %v, want: %v\n", err, ErrNotFound)
+	}
+	if _, err := idx.Get(ctx, make([]byte, MAX_KEY_SIZE+1)); !errors.Is(err, ErrKeyTooLarge) {
+		log.Fatalf("[index.index_test.TestGet] large key err: %v, want: %v\n", err, ErrKeyTooLarge)
+	}
+	if _, err := idx.Get(ctx, nil); !errors.Is(err, ErrEmptyKey) {
+		log.Fatalf("[index.index_test.TestGet] empty key err: %v, want: %v\n", err, ErrEmptyKey)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := idx.Get(canceled, []byte(mockKey[0])); !errors.Is(err, context.Canceled) {
+		log.Fatalf("[index.index_test.TestGet] canceled err: %v\n", err)
+	}
+
+	keys := [][]byte{[]byte(mockKey[0]), []byte("#not-a-key#"), []byte(mockKey[1])}
+	values, errs := idx.GetMany(ctx, keys)
+	if string(values[0]) != mockValue[0] || errs[0] != nil ||
+		string(values[2]) != mockValue[1] || errs[2] != nil {
+		log.Fatalf("[index.index_test.TestGet] GetMany values: %q, errs: %v\n", values, errs)
+	}
+	if values[1] != nil || !errors.Is(errs[1], ErrNotFound) {
+		log.Fatalf("[index.index_test.TestGet] GetMany missing key: %q, err: %v\n", values[1], errs[1])
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	ctx := context.Background()
+	for _, policy := range []CachePolicy{CacheLRU, CacheTinyLFU, Cache2Q, CacheARC, CacheClock} {
+		opts := testOptions(true, false)
+		opts.CachePolicy = policy
+		if policy == CacheLRU {
+			opts.CacheShards = 1
+		}
+		idx, err := New(opts)
+		if err != nil {
+			log.Fatalf("[index.index_test.TestCachePolicy] create index err: %v\n", err)
+		}
+		// the second pass is served by the cache
+		for pass := 0; pass < 2; pass++ {
+			for i, key := range mockKey[:200] {
+				value, err := idx.Get(ctx, []byte(key))
+				if err != nil || string(value) != mockValue[i] {
+					log.Fatalf("[index.index_test.TestCachePolicy] policy: %v, get key: %v, res: %s, err: %v, truth: %v\n",
+						policy, key, value, err, mockValue[i])
+				}
+			}
+		}
+		_ = idx.Close()
+	}
+}
+
+func TestStats(t *testing.T) {
+	mockKey, _ := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	ctx := context.Background()
+	for _, backend := range []Backend{BackendMap, BackendSplay, BackendMPH} {
+		opts := testOptions(true, false)
+		opts.Backend = backend
+		idx, err := New(opts)
+		if err != nil {
+			log.Fatalf("[index.index_test.TestStats] create index err: %v\n", err)
+		}
+		// the second pass is served by the cache
+		for pass := 0; pass < 2; pass++ {
+			for _, key := range mockKey[:200] {
+				if _, err = idx.Get(ctx, []byte(key)); err != nil {
+					log.Fatalf("[index.index_test.TestStats] get key: %v, err: %v\n", key, err)
+				}
+			}
+		}
+		for n := 0; n < 100; n++ {
+			_, _ = idx.Get(ctx, []byte("#not-a-key#"+strconv.Itoa(n)))
+		}
+		stats := idx.Stats()
+		// every lookup past the cache searches its chunk or is ruled out by its
+		// filter, while the mph table itself rules out some absent keys
+		searched := stats.ChunkScans+stats.FilterNegatives == 300
+		if backend == BackendMPH {
+			searched = stats.SlotReads >= 200 && stats.SlotReads <= 300
+		}
+		// about one lookup in SPLAY_PERIOD splays, and only with the splay tree
+		splayed := stats.Splays == 0
+		if backend == BackendSplay {
+			splayed = stats.Splays > 0 && stats.Splays < stats.ChunkScans
+		}
+		if stats.Cache.Hits != 200 || stats.Cache.Misses != 300 || stats.Cache.Adds != 200 ||
+			stats.Cache.Entries != 200 || stats.Cache.Bytes <= 0 ||
+			stats.Lookups != 300 || stats.NotFound != 100 || !searched || !splayed ||
+			stats.DataReads < 200 || stats.Candidates < 200 ||
+			stats.Candidates-stats.Collisions != 200 {
+			log.Fatalf("[index.index_test.TestStats] %v stats: %+v\n", backend, stats)
+		}
+		_ = idx.Close()
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	ctx := context.Background()
+	opts := testOptions(false, false)
+	opts.NegativeCacheSize = 1 << 20
+	opts.NegativeCacheTTL = 50 * time.Millisecond
+	idx, err := New(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestNegativeCache] create index err: %v\n", err)
+	}
+	defer idx.Close()
+	for n := 0; n < 100; n++ {
+		if _, err = idx.Get(ctx, []byte("#not-a-key#")); !errors.Is(err, ErrNotFound) {
+			log.Fatalf("[index.index_test.TestNegativeCache] missing key err: %v\n", err)
+		}
+		value, err := idx.Get(ctx, []byte(mockKey[0]))
+		if err != nil || string(value) != mockValue[0] {
+			log.Fatalf("[index.index_test.TestNegativeCache] get key: %v, res: %s, err: %v\n", mockKey[0], value, err)
+		}
+	}
+	// only the first lookup of the missing key searched the index
+	if stats := idx.Stats(); stats.NotFound != 1 || stats.Negative.Hits != 99 || stats.Lookups != 101 {
+		log.Fatalf("[index.index_test.TestNegativeCache] stats: %+v\n", stats)
+	}
+	time.Sleep(opts.NegativeCacheTTL)
+	if _, err = idx.Get(ctx, []byte("#not-a-key#")); !errors.Is(err, ErrNotFound) {
+		log.Fatalf("[index.index_test.TestNegativeCache] missing key err: %v\n", err)
+	}
+	if stats := idx.Stats(); stats.NotFound != 2 {
+		log.Fatalf("[index.index_test.TestNegativeCache] stats after the ttl: %+v\n", stats)
+	}
+}
+
 func FileRead() {
 	chunkN := 383
 	key := 309758383
@@ -139,14 +794,14 @@ func FileRead() {
 		curPos, _ = f.Seek(0, 1)
 	}
 	_ = f.Close()
-	c, _ := chunk.New(chunkN)
-	off, _ :=c.Index(uint32(key))
+	c, _ := chunk.New(".", chunkN)
+	off, _ :=c.Index(uint64(key))
 	log.Printf("index key: %v, res: %v\n", key, off)
 }
 
 func TestZipf(t *testing.T) {
 	zipf := rand.NewZipf(seededRand, 2, 1, 1000)
-	v := make([]uint64, 1000)
+	v := make([]uint64, 1001)
 	for i := 0; i < 100000; i++ {
 		z := zipf.Uint64()
 		v[z] += 1
@@ -155,7 +810,7 @@ func TestZipf(t *testing.T) {
 }
 
 func BenchmarkPer_Query_Lru_Splay(b *testing.B) {
-	mockKey, mockValue := genData()
+	mockKey, mockValue := genData(DATAFILE)
 	defer func() {
 		err := os.Remove(DATAFILE)
 		if err != nil {
@@ -165,7 +820,11 @@ func BenchmarkPer_Query_Lru_Splay(b *testing.B) {
 			_ = os.Remove(strconv.Itoa(i) + "_chunk")
 		}
 	}()
-	idx := New(true, true)
+	idx, err := New(testOptions(true, true))
+	if err != nil {
+		log.Fatalf("[index.index_test.BenchmarkPer_Query_Lru_Splay] create index err: %v\n", err)
+	}
+	defer idx.Close()
 	zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV)
 	mockKey, mockValue = shuffle(mockKey, mockValue)
 	log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage")
@@ -175,16 +834,18 @@ func BenchmarkPer_Query_Lru_Splay(b *testing.B) {
 	}
 	idx.Query(warmupQuery, 0)
 	log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage over\"")
+	resetReads(idx)
 	b.ResetTimer()
 	for i := 0; i < b.N; i ++  {
 		query := make([]string, 0)
 		query = append(query, mockKey[zipf.Uint64()])
 		idx.Query(query, 0)
 	}
+	reportReads(b, idx)
 }
 
 func BenchmarkPer_Query_Lru_HashMap(b *testing.B) {
-	mockKey, mockValue := genData()
+	mockKey, mockValue := genData(DATAFILE)
 	defer func() {
 		err := os.Remove(DATAFILE)
 		if err != nil {
@@ -194,7 +855,11 @@ func BenchmarkPer_Query_Lru_HashMap(b *testing.B) {
 			_ = os.Remove(strconv.Itoa(i) + "_chunk")
 		}
 	}()
-	idx := New(true, false)
+	idx, err := New(testOptions(true, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.BenchmarkPer_Query_Lru_HashMap] create index err: %v\n", err)
+	}
+	defer idx.Close()
 	zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV)
 	mockKey, mockValue = shuffle(mockKey, mockValue)
 	log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage")
@@ -204,16 +869,18 @@ func BenchmarkPer_Query_Lru_HashMap(b *testing.B) {
 	}
 	idx.Query(warmupQuery, 0)
 	log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage over\"")
+	resetReads(idx)
 	b.ResetTimer()
 	for i := 0; i < b.N; i ++  {
 		query := make([]string, 0)
 		query = append(query, mockKey[zipf.Uint64()])
 		idx.Query(query, 0)
 	}
+	reportReads(b, idx)
 }
 
 func BenchmarkPer_Query_HashMap(b *testing.B) {
-	mockKey, mockValue := genData()
+	mockKey, mockValue := genData(DATAFILE)
 	defer func() {
 		err := os.Remove(DATAFILE)
 		if err != nil {
@@ -223,7 +890,11 @@ func BenchmarkPer_Query_HashMap(b *testing.B) {
 			_ = os.Remove(strconv.Itoa(i) + "_chunk")
 		}
 	}()
-	idx := New(false, false)
+	idx, err := New(testOptions(false, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.BenchmarkPer_Query_HashMap] create index err: %v\n", err)
+	}
+	defer idx.Close()
 	zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV)
 	mockKey, mockValue = shuffle(mockKey, mockValue)
 	//log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage")
@@ -233,16 +904,18 @@ func BenchmarkPer_Query_HashMap(b *testing.B) {
 	//}
 	//idx.Query(warmupQuery, 0)
 	//log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage over\"")
+	resetReads(idx)
 	b.ResetTimer()
 	for i := 0; i < b.N; i ++  {
 		query := make([]string, 0)
 		query = append(query, mockKey[zipf.Uint64()])
 		idx.Query(query, 0)
 	}
+	reportReads(b, idx)
 }
 
 func BenchmarkPer_Query_Splay(b *testing.B) {
-	mockKey, mockValue := genData()
+	mockKey, mockValue := genData(DATAFILE)
 	defer func() {
 		err := os.Remove(DATAFILE)
 		if err != nil {
@@ -252,7 +925,11 @@ func BenchmarkPer_Query_Splay(b *testing.B) {
 			_ = os.Remove(strconv.Itoa(i) + "_chunk")
 		}
 	}()
-	idx := New(false, true)
+	idx, err := New(testOptions(false, true))
+	if err != nil {
+		log.Fatalf("[index.index_test.BenchmarkPer_Query_Splay] create index err: %v\n", err)
+	}
+	defer idx.Close()
 	zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV - 1)
 	mockKey, mockValue = shuffle(mockKey, mockValue)
 	log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage")
@@ -262,11 +939,460 @@ func BenchmarkPer_Query_Splay(b *testing.B) {
 	}
 	idx.Query(warmupQuery, 0)
 	log.Printf("[index.indext_test.BenchmarkIndex_Query_Lru_Splay] warnup stage over\"")
+	resetReads(idx)
 	b.ResetTimer()
 	for i := 0; i < b.N; i ++  {
 		query := make([]string, 0)
 		query = append(query, mockKey[zipf.Uint64()])
 		idx.Query(query, 0)
 	}
+	reportReads(b, idx)
+}
+
+
+func TestMPH(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	opts := testOptions(false, false)
+	opts.Backend = BackendMPH
+	idx, err := New(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestMPH] create index err: %v\n", err)
+	}
+	defer idx.Close()
+	ctx := context.Background()
+	check := func(idx *Index) {
+		for i, key := range mockKey {
+			value, err := idx.Get(ctx, []byte(key))
+			if err != nil || string(value) != mockValue[i] {
+				log.Fatalf("[index.index_test.TestMPH] get key: %v, res: %s, err: %v, truth: %v\n",
+					key, value, err, mockValue[i])
+			}
+		}
+		for i := 0; i < 100; i++ {
+			key := []byte("#not-a-key#" + strconv.Itoa(i))
+			if _, err := idx.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				log.Fatalf("[index.index_test.TestMPH] missing key err: %v, want: %v\n", err, ErrNotFound)
+			}
+		}
+	}
+	check(idx)
+	if _, err := os.Stat(chunk.Path(".", 0)); !os.IsNotExist(err) {
+		log.Fatalf("[index.index_test.TestMPH] chunk file written by mph backend: %v\n", err)
+	}
+
+	built, _ := os.Stat(manifestPath("."))
+	idx, err = Open(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestMPH] reopen index err: %v\n", err)
+	}
+	defer idx.Close()
+	check(idx)
+	reused, _ := os.Stat(manifestPath("."))
+	if !reused.ModTime().Equal(built.ModTime()) {
+		log.Fatalf("[index.index_test.TestMPH] index rebuilt although data file is unchanged")
+	}
+
+	// the chunk layout cannot reuse the mph files
+	idx, err = Open(testOptions(false, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.TestMPH] rebuild index err: %v\n", err)
+	}
+	defer idx.Close()
+	if idx.mph != nil {
+		log.Fatalf("[index.index_test.TestMPH] mph files reused by the map backend")
+	}
+	value, err := idx.Get(ctx, []byte(mockKey[0]))
+	if err != nil || string(value) != mockValue[0] {
+		log.Fatalf("[index.index_test.TestMPH] get after rebuild: %s, err: %v\n", value, err)
+	}
+
+	// the keys do not fit in a budget of a hundred keys
+	opts.BuildMemory = 100 * MPH_BUILD_KEY_SIZE
+	if _, err = New(opts); !errors.Is(err, ErrBuildMemory) {
+		log.Fatalf("[index.index_test.TestMPH] build beyond memory err: %v, want: %v\n", err, ErrBuildMemory)
+	}
+}
+
+func BenchmarkPer_Query_MPH(b *testing.B) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	opts := testOptions(false, false)
+	opts.Backend = BackendMPH
+	idx, err := New(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.BenchmarkPer_Query_MPH] create index err: %v\n", err)
+	}
+	defer idx.Close()
+	zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV - 1)
+	mockKey, mockValue = shuffle(mockKey, mockValue)
+	resetReads(idx)
+	b.ResetTimer()
+	for i := 0; i < b.N; i ++  {
+		query := make([]string, 0)
+		query = append(query, mockKey[zipf.Uint64()])
+		idx.Query(query, 0)
+	}
+	reportReads(b, idx)
+}
+
+func TestFilter(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	opts := testOptions(false, false)
+	idx, err := New(opts)
+	if err != nil {
+		log.Fatalf("[index.index_test.TestFilter] create index err: %v\n", err)
+	}
+	defer idx.Close()
+	ctx := context.Background()
+	check := func(idx *Index) {
+		for i, key := range mockKey {
+			value, err := idx.Get(ctx, []byte(key))
+			if err != nil || string(value) != mockValue[i] {
+				log.Fatalf("[index.index_test.TestFilter] get key: %v, res: %s, err: %v, truth: %v\n",
+					key, value, err, mockValue[i])
+			}
+		}
+		if idx.filters == nil {
+			return
+		}
+		rejected := 0
+		for i := 0; i < 1000; i++ {
+			key := []byte("#not-a-key#" + strconv.Itoa(i))
+			if _, err := idx.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				log.Fatalf("[index.index_test.TestFilter] missing key err: %v, want: %v\n", err, ErrNotFound)
+			}
+			keyHash := idx.opts.Hasher.Sum64(key)
+			if f := idx.filters[idx.chunkOf(keyHash)]; f == nil || !f.MayContain(keyHash) {
+				rejected++
+			}
+		}
+		if rejected < 990 {
+			log.Fatalf("[index.index_test.TestFilter] filters rejected %v of 1000 missing keys\n", rejected)
+		}
+	}
+	if idx.filters == nil {
+		log.Fatalf("[index.index_test.TestFilter] no filters built\n")
+	}
+	check(idx)
+
+	idx, err = Open(opts)
+	if err != nil || idx.filters == nil {
+		log.Fatalf("[index.index_test.TestFilter] reopen index filters: %v, err: %v\n", idx.filters != nil, err)
+	}
+	defer idx.Close()
+	check(idx)
+
+	// a corrupt filter file is rebuilt from the chunks
+	if err = ioutil.WriteFile(filepath.Join(".", FILTER_FILE), []byte("corrupt"), 0666); err != nil {
+		log.Fatalf("[index.index_test.TestFilter] write filter file err: %v\n", err)
+	}
+	idx, err = Open(opts)
+	if err != nil || idx.filters == nil {
+		log.Fatalf("[index.index_test.TestFilter] rebuild filters: %v, err: %v\n", idx.filters != nil, err)
+	}
+	defer idx.Close()
+	check(idx)
+
+	// the filters fit in the budget rounded up to whole blocks
+	opts.FilterBits = int64(len(idx.chunkIds)) * bloom.BLOCK_BITS
+	if idx, err = Open(opts); err != nil || idx.filters == nil {
+		log.Fatalf("[index.index_test.TestFilter] filter bits %v: filters: %v, err: %v\n",
+			opts.FilterBits, idx.filters != nil, err)
+	}
+	defer idx.Close()
+	check(idx)
+	bits := 0
+	for _, f := range idx.filters {
+		if f != nil {
+			bits += f.Bits()
+		}
+	}
+	if int64(bits) > opts.FilterBits {
+		log.Fatalf("[index.index_test.TestFilter] filters of %v bits over a budget of %v\n", bits, opts.FilterBits)
+	}
+
+	// a budget below a bit per key, or a block per chunk, disables the filters
+	for _, bits := range []int64{0, NUM_KV / 2, opts.FilterBits - 1} {
+		opts.FilterBits = bits
+		if idx, err = Open(opts); err != nil || idx.filters != nil {
+			log.Fatalf("[index.index_test.TestFilter] filter bits %v: filters: %v, err: %v\n",
+				bits, idx.filters != nil, err)
+		}
+		check(idx)
+		_ = idx.Close()
+	}
+}
+
+func BenchmarkGet_Missing(b *testing.B) {
+	genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for _, bits := range []int64{0, FILTER_BITS} {
+		b.Run("filter_bits_"+strconv.FormatInt(bits, 10), func(b *testing.B) {
+			opts := testOptions(false, false)
+			opts.FilterBits = bits
+			idx, err := Open(opts)
+			if err != nil {
+				log.Fatalf("[index.index_test.BenchmarkGet_Missing] create index err: %v\n", err)
+			}
+			defer idx.Close()
+			ctx := context.Background()
+			resetReads(idx)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = idx.Get(ctx, []byte("#not-a-key#"+strconv.Itoa(i)))
+			}
+			reportReads(b, idx)
+		})
+	}
+}
+
+// resetReads clears the lookup counters of idx, before a timed loop.
+func resetReads(idx *Index) {
+	idx.counters = counters{}
+	if idx.mph != nil {
+		idx.mph.slotReads = 0
+	}
+}
+
+// reportReads reports the records found per op for the looked up hashes, and
+// those read from the data file once their fingerprint matched.
+func reportReads(b *testing.B, idx *Index) {
+	stats := idx.Stats()
+	b.ReportMetric(float64(stats.Candidates)/float64(b.N), "candidates/op")
+	b.ReportMetric(float64(stats.DataReads)/float64(b.N), "reads/op")
+}
+
+// weakHasher keeps 6 bits of xxHash64, so that the keys collide as often as
+// in a dataset far larger than the tests.
+type weakHasher struct{}
+
+func (weakHasher) Name() string {
+	return "weak6"
+}
+
+func (weakHasher) Sum64(key []byte) uint64 {
+	return XXHash64().Sum64(key) & 0x3f
+}
+
+func TestFingerprint(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for n, backend := range []Backend{BackendMap, BackendMPH, BackendMap} {
+		opts := testOptions(false, false)
+		opts.Backend = backend
+		opts.Hasher = weakHasher{}
+		opts.TrustFingerprint = n == 2
+		idx, err := New(opts)
+		if err != nil {
+			log.Fatalf("[index.index_test.TestFingerprint] create index err: %v\n", err)
+		}
+		resetReads(idx)
+		ctx := context.Background()
+		for i, key := range mockKey {
+			value, err := idx.Get(ctx, []byte(key))
+			if err != nil || string(value) != mockValue[i] {
+				log.Fatalf("[index.index_test.TestFingerprint] %v get key: %v, res: %s, err: %v, truth: %v\n",
+					backend, key, value, err, mockValue[i])
+			}
+		}
+		// about NUM_KV / 64 keys share each hash, but only the key read
+		if stats := idx.Stats(); stats.Candidates < 10*NUM_KV || stats.DataReads > NUM_KV+5 {
+			log.Fatalf("[index.index_test.TestFingerprint] %v %v candidates, %v reads for %v keys\n",
+				backend, stats.Candidates, stats.DataReads, NUM_KV)
+		}
+		_ = idx.Close()
+	}
 }
 
+func BenchmarkGet_Fingerprint(b *testing.B) {
+	mockKey, _ := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for n, hasher := range []Hasher{XXHash64(), weakHasher{}, XXHash64()} {
+		name := hasher.Name()
+		if n == 2 {
+			name += "_trusted"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := testOptions(false, false)
+			opts.Hasher = hasher
+			opts.TrustFingerprint = n == 2
+			idx, err := New(opts)
+			if err != nil {
+				log.Fatalf("[index.index_test.BenchmarkGet_Fingerprint] create index err: %v\n", err)
+			}
+			defer idx.Close()
+			ctx := context.Background()
+			resetReads(idx)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = idx.Get(ctx, []byte(mockKey[i%len(mockKey)]))
+			}
+			reportReads(b, idx)
+		})
+	}
+}
+
+func TestConcurrentGet(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	ctx := context.Background()
+	for _, useSplay := range []bool{false, true} {
+		opts := testOptions(false, useSplay)
+		// a few chunks, so that many lookups hit the same chunk at once, and
+		// enough for the splay tree to splay the chunks it finds
+		opts.ChunkNum = 4
+		if useSplay {
+			opts.ChunkNum = 32
+			opts.SplayPeriod = 2
+		}
+		idx, err := New(opts)
+		if err != nil {
+			log.Fatalf("[index.index_test.TestConcurrentGet] create index err: %v\n", err)
+		}
+		wg := sync.WaitGroup{}
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < len(mockKey); i += 4 {
+					value, err := idx.Get(ctx, []byte(mockKey[i]))
+					if err != nil || string(value) != mockValue[i] {
+						log.Fatalf("[index.index_test.TestConcurrentGet] get key: %v, res: %s, err: %v, truth: %v\n",
+							mockKey[i], value, err, mockValue[i])
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		if useSplay && idx.Stats().Splays == 0 {
+			log.Fatalf("[index.index_test.TestConcurrentGet] no lookup splayed: %+v\n", idx.Stats())
+		}
+
+		if err = idx.Close(); err != nil {
+			log.Fatalf("[index.index_test.TestConcurrentGet] close index err: %v\n", err)
+		}
+		if _, err = idx.Get(ctx, []byte(mockKey[0])); !errors.Is(err, os.ErrClosed) {
+			log.Fatalf("[index.index_test.TestConcurrentGet] get after close err: %v, want: %v\n", err, os.ErrClosed)
+		}
+	}
+}
+
+func BenchmarkGet_Parallel(b *testing.B) {
+	mockKey, _ := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	idx, err := New(testOptions(false, false))
+	if err != nil {
+		log.Fatalf("[index.index_test.BenchmarkGet_Parallel] create index err: %v\n", err)
+	}
+	defer idx.Close()
+	ctx := context.Background()
+	zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV - 1)
+	queries := make([]uint64, 1 << 16)
+	for i := range queries {
+		queries[i] = zipf.Uint64()
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := rand.Int()
+		for pb.Next() {
+			n++
+			_, _ = idx.Get(ctx, []byte(mockKey[queries[n%len(queries)]]))
+		}
+	})
+}
+
+func TestMmap(t *testing.T) {
+	mockKey, mockValue := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	ctx := context.Background()
+	for n, mode := range []BuildMode{BuildExternalSort, BuildAppend, BuildExternalSort} {
+		opts := testOptions(false, n == 1)
+		opts.BuildMode = mode
+		opts.Mmap = true
+		open := New
+		if n == 2 {
+			// reuse the chunks built by the previous round
+			open = Open
+		}
+		idx, err := open(opts)
+		if errors.Is(err, chunk.ErrMmapUnsupported) {
+			t.Skip(err)
+		}
+		if err != nil {
+			log.Fatalf("[index.index_test.TestMmap] create index err: %v\n", err)
+		}
+		for id := range idx.chunkIds {
+			if c := idx.findChunk(id); !c.Mapped() && c.Count() > 0 {
+				log.Fatalf("[index.index_test.TestMmap] chunk %v of %v is not mapped\n", id, mode)
+			}
+		}
+		for i, key := range mockKey {
+			value, err := idx.Get(ctx, []byte(key))
+			if err != nil || string(value) != mockValue[i] {
+				log.Fatalf("[index.index_test.TestMmap] get key: %v, res: %s, err: %v, truth: %v\n",
+					key, value, err, mockValue[i])
+			}
+		}
+		if err = idx.Close(); err != nil {
+			log.Fatalf("[index.index_test.TestMmap] close index err: %v\n", err)
+		}
+	}
+}
+
+func BenchmarkGet_Mmap(b *testing.B) {
+	mockKey, _ := genData(DATAFILE)
+	defer func() {
+		removeIndex(DefaultOptions())
+		_ = os.Remove(DATAFILE)
+	}()
+	for _, mmap := range []bool{false, true} {
+		name := "file"
+		if mmap {
+			name = "mmap"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := testOptions(false, false)
+			opts.Mmap = mmap
+			idx, err := Open(opts)
+			if err != nil {
+				log.Fatalf("[index.index_test.BenchmarkGet_Mmap] create index err: %v\n", err)
+			}
+			defer idx.Close()
+			ctx := context.Background()
+			zipf := rand.NewZipf(seededRand, 2, 2, NUM_KV - 1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = idx.Get(ctx, []byte(mockKey[zipf.Uint64()]))
+			}
+		})
+	}
+}
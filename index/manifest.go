@@ -0,0 +1,178 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tabVersion/index-kv/chunk"
+)
+
+const (
+	MANIFEST_FILE    = "index_manifest"
+	MANIFEST_VERSION = 6
+
+	LAYOUT_CHUNKS = "chunks"
+	LAYOUT_MPH    = "mph"
+
+	// the checksum covers CHECKSUM_BLOCKS evenly spaced blocks of the data
+	// file instead of the whole file, hashing 1T on every start would cost
+	// as much as rebuilding the index.
+	CHECKSUM_BLOCKS     = 64
+	CHECKSUM_BLOCK_SIZE = 64 << 10
+)
+
+var (
+	errStaleManifest = errors.New("stale manifest")
+	// ErrHashMismatch is returned by Open for an index built with another
+	// Hasher, which it refuses to read or to overwrite.
+	ErrHashMismatch = errors.New("index built with another hasher")
+)
+
+// manifest describes a complete index on disk. It is written only after every
+// chunk has been flushed, so its presence means the build finished.
+type manifest struct {
+	Version   int      `json:"version"`
+	DataFile  string   `json:"data_file"`
+	DataSize  int64    `json:"data_size"`
+	DataMtime int64    `json:"data_mtime"`
+	Checksum  uint32   `json:"checksum"`
+	ChunkNum  int      `json:"chunk_num"`
+	Hash      string   `json:"hash"`
+	HashCheck uint64   `json:"hash_check"`
+	Layout    string   `json:"layout"`
+	Chunks    []uint32 `json:"chunks"`
+}
+
+// newManifest describes the current state of opts.DataFile, without any chunk.
+func newManifest(opts Options) (*manifest, error) {
+	f, err := os.Open(opts.DataFile)
+	if err != nil {
+		return nil, fmt.Errorf("open data file %v: %w", opts.DataFile, err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat data file %v: %w", opts.DataFile, err)
+	}
+	checksum, err := sampleChecksum(f, stat.Size())
+	if err != nil {
+		return nil, fmt.Errorf("checksum data file %v: %w", opts.DataFile, err)
+	}
+	layout := LAYOUT_CHUNKS
+	if opts.Backend == BackendMPH {
+		layout = LAYOUT_MPH
+	}
+	return &manifest{
+		Version:   MANIFEST_VERSION,
+		DataFile:  opts.DataFile,
+		DataSize:  stat.Size(),
+		DataMtime: stat.ModTime().UnixNano(),
+		Checksum:  checksum,
+		ChunkNum:  opts.ChunkNum,
+		Hash:      opts.Hasher.Name(),
+		HashCheck: hashCheck(opts.Hasher),
+		Layout:    layout,
+	}, nil
+}
+
+func sampleChecksum(f *os.File, size int64) (uint32, error) {
+	h := crc32.NewIEEE()
+	buf := make([]byte, CHECKSUM_BLOCK_SIZE)
+	if size <= CHECKSUM_BLOCKS*CHECKSUM_BLOCK_SIZE {
+		if _, err := io.Copy(h, io.NewSectionReader(f, 0, size)); err != nil {
+			return 0, err
+		}
+		return h.Sum32(), nil
+	}
+	step := (size - CHECKSUM_BLOCK_SIZE) / (CHECKSUM_BLOCKS - 1)
+	for i := int64(0); i < CHECKSUM_BLOCKS; i++ {
+		n, err := f.ReadAt(buf, i*step)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		_, _ = h.Write(buf[:n])
+	}
+	return h.Sum32(), nil
+}
+
+func manifestPath(dir string) string {
+	return filepath.Join(dir, MANIFEST_FILE)
+}
+
+func loadManifest(dir string) (*manifest, error) {
+	content, err := ioutil.ReadFile(manifestPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	m := new(manifest)
+	if err = json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return m, nil
+}
+
+// save writes the manifest through a temporary file so that a crash never
+// leaves a truncated manifest behind.
+func (m *manifest) save(dir string) error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := manifestPath(dir) + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manifestPath(dir))
+}
+
+// check reports whether the index in dir described by m can serve cur, the
+// manifest of the data file as it is now.
+func (m *manifest) check(dir string, cur *manifest) error {
+	switch {
+	case m.Version != cur.Version:
+		return fmt.Errorf("%w: version %v, want %v", errStaleManifest, m.Version, cur.Version)
+	case m.Hash != cur.Hash || m.HashCheck != cur.HashCheck:
+		return fmt.Errorf("%w: %v, opened with %v", ErrHashMismatch, m.Hash, cur.Hash)
+	case m.DataFile != cur.DataFile || m.DataSize != cur.DataSize ||
+		m.DataMtime != cur.DataMtime || m.Checksum != cur.Checksum:
+		return fmt.Errorf("%w: data file %v changed", errStaleManifest, cur.DataFile)
+	case m.ChunkNum != cur.ChunkNum:
+		return fmt.Errorf("%w: chunk num %v, want %v", errStaleManifest, m.ChunkNum, cur.ChunkNum)
+	case m.Layout != cur.Layout:
+		return fmt.Errorf("%w: layout %v, want %v", errStaleManifest, m.Layout, cur.Layout)
+	}
+	for _, id := range m.Chunks {
+		if _, err := os.Stat(chunk.Path(dir, int(id))); err != nil {
+			return fmt.Errorf("%w: chunk %v: %v", errStaleManifest, id, err)
+		}
+	}
+	return nil
+}
+
+// removeIndex drops the manifest and every chunk file of opts.IndexDir, so
+// that a following build starts from empty chunks.
+func removeIndex(opts Options) {
+	_ = os.Remove(manifestPath(opts.IndexDir))
+	for _, name := range []string{FILTER_FILE, SPLAY_FILE, MPH_TABLE_FILE, MPH_SLOTS_FILE, MPH_OVERFLOW_FILE} {
+		_ = os.Remove(filepath.Join(opts.IndexDir, name))
+	}
+	// every chunk file rather than those below ChunkNum, which may have been
+	// larger for the former index
+	for _, pattern := range []string{"*_chunk", "*_chunk.seal"} {
+		names, _ := filepath.Glob(filepath.Join(opts.IndexDir, pattern))
+		for _, name := range names {
+			id := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(name), ".seal"), "_chunk")
+			if _, err := strconv.ParseUint(id, 10, 32); err == nil {
+				_ = os.Remove(name)
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/tabVersion/index-kv/chunk"
+	"github.com/tabVersion/index-kv/mph"
+)
+
+// The BackendMPH files: the minimal perfect hash table of the key hashes, the
+// data file offset of every key at its table index, and the keys whose hash
+// is shared with another key, which the table cannot tell apart.
+const (
+	MPH_TABLE_FILE    = "mph_table"
+	MPH_SLOTS_FILE    = "mph_slots"
+	MPH_OVERFLOW_FILE = "mph_overflow"
+
+	// MPH_SLOT_SIZE is the size of a slot: fingerprint uint32 | key size uint32 |
+	// value size uint32 | offset uint64.
+	MPH_SLOT_SIZE = 20
+	// MPH_OVERFLOW_SIZE is the size of an overflow entry: hash uint64 | slot.
+	MPH_OVERFLOW_SIZE = 8 + MPH_SLOT_SIZE
+	// MPH_BUILD_KEY_SIZE is the memory the build takes per key: its record,
+	// its hash handed to the table, its slot and its bits in the levels.
+	MPH_BUILD_KEY_SIZE = 64
+)
+
+// mphIndex is BackendMPH. A lookup is one hash evaluation, one read of the
+// slot and, when its fingerprint matches, one read of the data file.
+type mphIndex struct {
+	// slotReads counts the slots read, atomically
+	slotReads int64
+	hasher    Hasher
+	table    *mph.Table
+	slots    *os.File
+	overflow map[uint64][]chunk.Record
+}
+
+func encodeSlot(buf []byte, r chunk.Record) {
+	binary.LittleEndian.PutUint32(buf, r.Fingerprint)
+	binary.LittleEndian.PutUint32(buf[4:], r.KeySize)
+	binary.LittleEndian.PutUint32(buf[8:], r.ValueSize)
+	binary.LittleEndian.PutUint64(buf[12:], r.Offset)
+}
+
+func decodeSlot(buf []byte, hash uint64) chunk.Record {
+	return chunk.Record{
+		Hash:        hash,
+		Fingerprint: binary.LittleEndian.Uint32(buf),
+		KeySize:     binary.LittleEndian.Uint32(buf[4:]),
+		ValueSize:   binary.LittleEndian.Uint32(buf[8:]),
+		Offset:      binary.LittleEndian.Uint64(buf[12:]),
+	}
+}
+
+// locate returns the record in the slot of key. A key that was not in the
+// data file still maps to some slot, which its fingerprint rules out.
+func (m *mphIndex) locate(key []byte) ([]chunk.Record, error) {
+	keyHash := m.hasher.Sum64(key)
+	if records, exist := m.overflow[keyHash]; exist {
+		return records, nil
+	}
+	idx, ok := m.table.Lookup(keyHash)
+	if !ok {
+		return nil, fmt.Errorf("%w: key %s", ErrNotFound, key)
+	}
+	atomic.AddInt64(&m.slotReads, 1)
+	buf := make([]byte, MPH_SLOT_SIZE)
+	if _, err := m.slots.ReadAt(buf, int64(idx)*MPH_SLOT_SIZE); err != nil {
+		return nil, fmt.Errorf("read mph slot %v: %w", idx, err)
+	}
+	return []chunk.Record{decodeSlot(buf, keyHash)}, nil
+}
+
+// collect is the ingest of BackendMPH, it gathers the record of every key in
+// memory. It fails with ErrBuildMemory as soon as the keys collected so far
+// need more than BuildMemory, rather than run out of memory.
+func (b *builder) collect(segments <-chan segment) {
+	pairs := make([]chunk.Record, 0)
+	for seg := range segments {
+		if b.failed() {
+			continue
+		}
+		keys := atomic.AddInt64(&b.collected, int64(len(seg.starts)))
+		if keys*MPH_BUILD_KEY_SIZE > b.index.opts.BuildMemory {
+			b.fail(fmt.Errorf("%w: %v keys take %v bytes with BackendMPH, more than %v",
+				ErrBuildMemory, keys, keys*MPH_BUILD_KEY_SIZE, b.index.opts.BuildMemory))
+			continue
+		}
+		for _, pos := range seg.starts {
+			pairs = append(pairs, b.record(seg, pos))
+		}
+	}
+	b.mutex.Lock()
+	b.pairs = append(b.pairs, pairs...)
+	b.mutex.Unlock()
+}
+
+// buildMPH builds the table over the collected hashes and writes the BackendMPH
+// files. It holds up to MPH_BUILD_KEY_SIZE bytes per key in memory.
+func (b *builder) buildMPH() error {
+	pairs := b.pairs
+	b.pairs = nil
+	sort.Slice(pairs, func(x, y int) bool {
+		if pairs[x].Hash != pairs[y].Hash {
+			return pairs[x].Hash < pairs[y].Hash
+		}
+		return pairs[x].Offset < pairs[y].Offset
+	})
+	overflow := make(map[uint64][]chunk.Record)
+	unique := pairs[:0]
+	for n := 0; n < len(pairs); {
+		end := n + 1
+		for end < len(pairs) && pairs[end].Hash == pairs[n].Hash {
+			end++
+		}
+		if end-n == 1 {
+			unique = append(unique, pairs[n])
+		} else {
+			overflow[pairs[n].Hash] = append([]chunk.Record(nil), pairs[n:end]...)
+		}
+		n = end
+	}
+
+	keys := make([]uint64, len(unique))
+	for n, p := range unique {
+		keys[n] = p.Hash
+	}
+	table, err := mph.Build(keys, b.index.opts.MPHGamma)
+	if err != nil {
+		return fmt.Errorf("build mph: %w", err)
+	}
+	keys = nil
+	slots := make([]byte, MPH_SLOT_SIZE*len(unique))
+	for _, p := range unique {
+		idx, _ := table.Lookup(p.Hash)
+		encodeSlot(slots[MPH_SLOT_SIZE*idx:], p)
+	}
+
+	dir := b.index.opts.IndexDir
+	content, _ := table.MarshalBinary()
+	if err = ioutil.WriteFile(filepath.Join(dir, MPH_TABLE_FILE), content, 0666); err != nil {
+		return fmt.Errorf("write mph table: %w", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, MPH_SLOTS_FILE), slots, 0666); err != nil {
+		return fmt.Errorf("write mph slots: %w", err)
+	}
+	if err = writeOverflow(filepath.Join(dir, MPH_OVERFLOW_FILE), overflow); err != nil {
+		return fmt.Errorf("write mph overflow: %w", err)
+	}
+	b.index.mph, err = loadMPH(dir, b.index.opts.Hasher)
+	return err
+}
+
+func writeOverflow(path string, overflow map[uint64][]chunk.Record) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	buf := make([]byte, MPH_OVERFLOW_SIZE)
+	for hash, records := range overflow {
+		for _, r := range records {
+			binary.LittleEndian.PutUint64(buf, hash)
+			encodeSlot(buf[8:], r)
+			if _, err = bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// loadMPH opens the BackendMPH files of dir, built with hasher.
+func loadMPH(dir string, hasher Hasher) (*mphIndex, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, MPH_TABLE_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("read mph table: %w", err)
+	}
+	table := new(mph.Table)
+	if err = table.UnmarshalBinary(content); err != nil {
+		return nil, err
+	}
+	content, err = ioutil.ReadFile(filepath.Join(dir, MPH_OVERFLOW_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("read mph overflow: %w", err)
+	}
+	if len(content)%MPH_OVERFLOW_SIZE != 0 {
+		return nil, fmt.Errorf("corrupt mph overflow of %v bytes", len(content))
+	}
+	overflow := make(map[uint64][]chunk.Record)
+	for n := 0; n < len(content); n += MPH_OVERFLOW_SIZE {
+		hash := binary.LittleEndian.Uint64(content[n:])
+		overflow[hash] = append(overflow[hash], decodeSlot(content[n+8:], hash))
+	}
+	slots, err := os.Open(filepath.Join(dir, MPH_SLOTS_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("open mph slots: %w", err)
+	}
+	stat, err := slots.Stat()
+	if err != nil || stat.Size() != MPH_SLOT_SIZE*int64(table.Len()) {
+		_ = slots.Close()
+		return nil, fmt.Errorf("mph slots do not match the table, err: %v", err)
+	}
+	return &mphIndex{hasher: hasher, table: table, slots: slots, overflow: overflow}, nil
+}
@@ -0,0 +1,307 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/tabVersion/index-kv/cache"
+)
+
+// Backend selects the structure that maps a key to its offsets.
+type Backend int
+
+const (
+	// BackendMap keeps the chunks in a builtin map.
+	BackendMap Backend = iota
+	// BackendSplay keeps the chunks in a splay tree so that hot chunks are
+	// found near the root.
+	BackendSplay
+	// BackendMPH replaces the chunks with a minimal perfect hash function over
+	// every key and a flat array of offsets on disk.
+	BackendMPH
+)
+
+func (b Backend) String() string {
+	switch b {
+	case BackendMap:
+		return "map"
+	case BackendSplay:
+		return "splay"
+	case BackendMPH:
+		return "mph"
+	}
+	return fmt.Sprintf("Backend(%d)", int(b))
+}
+
+// CachePolicy selects which values the cache keeps.
+type CachePolicy int
+
+const (
+	// CacheLRU evicts the least recently used values.
+	CacheLRU CachePolicy = iota
+	// CacheTinyLFU only admits a value in place of values whose keys were
+	// accessed less often recently (W-TinyLFU), so that bulk scans of one-off
+	// keys do not flush the hot values.
+	CacheTinyLFU
+	// Cache2Q keeps the values accessed again apart from those accessed once,
+	// and evicts the latter first.
+	Cache2Q
+	// CacheARC adapts the share of the values accessed once and of those
+	// accessed again to the misses on recently evicted keys.
+	CacheARC
+	// CacheClock approximates LRU with the CLOCK algorithm, whose hits only
+	// take a read lock.
+	CacheClock
+)
+
+func (p CachePolicy) String() string {
+	switch p {
+	case CacheLRU:
+		return "lru"
+	case CacheTinyLFU:
+		return "tinylfu"
+	case Cache2Q:
+		return "2q"
+	case CacheARC:
+		return "arc"
+	case CacheClock:
+		return "clock"
+	}
+	return fmt.Sprintf("CachePolicy(%d)", int(p))
+}
+
+// BuildMode selects how New turns the data file into sealed chunks.
+type BuildMode int
+
+const (
+	// BuildExternalSort buffers records within BuildMemory, spills them as
+	// sorted runs and merges the runs into sealed chunks.
+	BuildExternalSort BuildMode = iota
+	// BuildAppend appends records to every chunk file as they are read and
+	// seals each chunk in memory once the data file is consumed.
+	BuildAppend
+)
+
+func (m BuildMode) String() string {
+	switch m {
+	case BuildExternalSort:
+		return "external-sort"
+	case BuildAppend:
+		return "append"
+	}
+	return fmt.Sprintf("BuildMode(%d)", int(m))
+}
+
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Options configures an Index. Zero fields take the defaults of
+// DefaultOptions, except CacheSize and FilterBits where zero disables the
+// cache and the filters.
+type Options struct {
+	// DataFile is the path of the key-value data file.
+	DataFile string
+	// IndexDir is the directory holding the chunk files and the manifest.
+	IndexDir string
+	// ChunkNum is the number of chunks the key hashes are sharded into.
+	ChunkNum int
+	// CacheSize is the capacity of the LRU cache in bytes, counting the keys,
+	// the values and a fixed overhead per entry, 0 disables it.
+	CacheSize int64
+	// CachePolicy selects the eviction policy of the cache.
+	CachePolicy CachePolicy
+	// CacheShards splits the cache into independent shards with a share of
+	// CacheSize each, so that concurrent lookups of different keys do not
+	// contend on one lock. 1 keeps a single cache. A value is only cached when
+	// it fits in a shard along with its key and cache.ENTRY_OVERHEAD, so the
+	// largest cacheable value is a little under CacheSize/CacheShards, 4M
+	// with the defaults, less the ghosts kept by Cache2Q and CacheARC. New
+	// rejects a CacheSize or NegativeCacheSize whose shards cannot hold the
+	// largest key and value of MaxKeySize and MaxValueSize.
+	CacheShards int
+	// NegativeCacheSize is the capacity in bytes of the cache of the keys
+	// found absent from the data file, so that lookups of the same absent key
+	// are answered in memory, 0 disables it.
+	NegativeCacheSize int64
+	// NegativeCacheTTL is how long an absent key stays cached, 0 keeps it
+	// until evicted.
+	NegativeCacheTTL time.Duration
+	// Mmap maps the sealed chunk files into memory, so that lookups of hot
+	// chunks are served by the page cache without a system call. Linux only.
+	Mmap bool
+	// FilterBits is the memory budget in bits of the Bloom filters that answer
+	// most lookups of absent keys without reading the chunks, 0 disables them.
+	// The filters take up to 16 bits per key, rounded up to whole blocks of
+	// 512 bits per chunk, and fewer bits per key when that exceeds the budget.
+	// The default of 256MB gives 16 bits per key up to about 134 million keys.
+	// BackendMPH has no filters.
+	FilterBits int64
+	// MaxRoutines bounds the goroutines spawned by Query and GetMany.
+	MaxRoutines int
+	// BuildWorkers is the number of goroutines ingesting the data file in New.
+	BuildWorkers int
+	// BuildReaders is the number of ranges of the data file read concurrently
+	// in New. 1 reads it sequentially, which suits a spinning disk better.
+	BuildReaders int
+	// BuildMode selects how New builds the chunks.
+	BuildMode BuildMode
+	// BuildMemory bounds the bytes of records buffered by BuildExternalSort.
+	// BackendMPH holds every key in memory instead and fails with
+	// ErrBuildMemory when they need more.
+	BuildMemory int64
+
+	MinKeySize   int
+	MaxKeySize   int
+	MinValueSize int
+	MaxValueSize int
+
+	Backend Backend
+	// Hasher hashes the keys. An index can only be opened with the hasher,
+	// and the key of a keyed hasher, that built it.
+	Hasher Hasher
+	// TrustFingerprint skips reading and comparing the key of a record whose
+	// hash, fingerprint and key size match, reading only its value. A lookup
+	// of an absent key then gets the value of another key with a probability
+	// of about 2^-96 per key of its chunk, or 2^-32 with BackendMPH, which
+	// does not keep the hashes.
+	TrustFingerprint bool
+	// MPHGamma is the bits per key of each level of BackendMPH, at least 1.
+	// Larger values build and look up faster but take more memory.
+	MPHGamma float64
+	// SplayPeriod is how many lookups of BackendSplay find a chunk deep in the
+	// tree for one of them to splay it to the root, the others only reading
+	// the tree so that they proceed in parallel. 1 splays every lookup.
+	SplayPeriod int
+}
+
+// DefaultOptions returns the options the index used before they were
+// configurable: DATAFILE and chunk files in the working directory.
+func DefaultOptions() Options {
+	return Options{
+		DataFile:     DATAFILE,
+		IndexDir:     ".",
+		ChunkNum:     CHUNK_NUM,
+		CacheSize:    CACHE_SIZE,
+		CacheShards:  CACHE_SHARDS,
+		SplayPeriod:  SPLAY_PERIOD,
+		FilterBits:   FILTER_BITS,
+		MaxRoutines:  MAX_ROUTINE_LIMIT,
+		BuildWorkers: runtime.NumCPU(),
+		BuildReaders: runtime.NumCPU(),
+		BuildMode:    BuildExternalSort,
+		BuildMemory:  BUILD_MEMORY,
+		MinKeySize:   MIN_KEY_SIZE,
+		MaxKeySize:   MAX_KEY_SIZE,
+		MinValueSize: MIN_VALUE_SIZE,
+		MaxValueSize: MAX_VALUE_SIZE,
+		Backend:      BackendMap,
+		Hasher:       XXHash64(),
+		MPHGamma:     MPH_GAMMA,
+	}
+}
+
+// normalize fills zero fields with their defaults and validates the result.
+func (o Options) normalize() (Options, error) {
+	d := DefaultOptions()
+	if o.DataFile == "" {
+		o.DataFile = d.DataFile
+	}
+	if o.IndexDir == "" {
+		o.IndexDir = d.IndexDir
+	}
+	if o.ChunkNum == 0 {
+		o.ChunkNum = d.ChunkNum
+	}
+	if o.CacheShards == 0 {
+		o.CacheShards = d.CacheShards
+	}
+	if o.SplayPeriod == 0 {
+		o.SplayPeriod = d.SplayPeriod
+	}
+	if o.MaxRoutines == 0 {
+		o.MaxRoutines = d.MaxRoutines
+	}
+	if o.BuildWorkers == 0 {
+		o.BuildWorkers = d.BuildWorkers
+	}
+	if o.BuildReaders == 0 {
+		o.BuildReaders = d.BuildReaders
+	}
+	if o.BuildMemory == 0 {
+		o.BuildMemory = d.BuildMemory
+	}
+	if o.MinKeySize == 0 {
+		o.MinKeySize = d.MinKeySize
+	}
+	if o.MaxKeySize == 0 {
+		o.MaxKeySize = d.MaxKeySize
+	}
+	if o.MinValueSize == 0 {
+		o.MinValueSize = d.MinValueSize
+	}
+	if o.MaxValueSize == 0 {
+		o.MaxValueSize = d.MaxValueSize
+	}
+	if o.Hasher == nil {
+		o.Hasher = d.Hasher
+	}
+	if o.MPHGamma == 0 {
+		o.MPHGamma = d.MPHGamma
+	}
+
+	switch {
+	case o.ChunkNum < 0:
+		return o, fmt.Errorf("%w: chunk num %v", ErrInvalidOptions, o.ChunkNum)
+	case o.CacheSize < 0:
+		return o, fmt.Errorf("%w: cache size %v", ErrInvalidOptions, o.CacheSize)
+	case o.NegativeCacheSize < 0:
+		return o, fmt.Errorf("%w: negative cache size %v", ErrInvalidOptions, o.NegativeCacheSize)
+	case o.NegativeCacheTTL < 0:
+		return o, fmt.Errorf("%w: negative cache ttl %v", ErrInvalidOptions, o.NegativeCacheTTL)
+	case o.CacheShards < 0:
+		return o, fmt.Errorf("%w: cache shards %v", ErrInvalidOptions, o.CacheShards)
+	case o.SplayPeriod < 0:
+		return o, fmt.Errorf("%w: splay period %v", ErrInvalidOptions, o.SplayPeriod)
+	case o.CachePolicy < CacheLRU || o.CachePolicy > CacheClock:
+		return o, fmt.Errorf("%w: cache policy %v", ErrInvalidOptions, o.CachePolicy)
+	case o.FilterBits < 0:
+		return o, fmt.Errorf("%w: filter bits %v", ErrInvalidOptions, o.FilterBits)
+	case o.MaxRoutines < 0:
+		return o, fmt.Errorf("%w: max routines %v", ErrInvalidOptions, o.MaxRoutines)
+	case o.BuildWorkers < 0:
+		return o, fmt.Errorf("%w: build workers %v", ErrInvalidOptions, o.BuildWorkers)
+	case o.BuildReaders < 0:
+		return o, fmt.Errorf("%w: build readers %v", ErrInvalidOptions, o.BuildReaders)
+	case o.BuildMode != BuildExternalSort && o.BuildMode != BuildAppend:
+		return o, fmt.Errorf("%w: build mode %v", ErrInvalidOptions, o.BuildMode)
+	case o.BuildMemory < 0:
+		return o, fmt.Errorf("%w: build memory %v", ErrInvalidOptions, o.BuildMemory)
+	case o.MinKeySize < 0 || o.MaxKeySize < o.MinKeySize:
+		return o, fmt.Errorf("%w: key size range [%v, %v]", ErrInvalidOptions, o.MinKeySize, o.MaxKeySize)
+	case o.MinValueSize < 0 || o.MaxValueSize < o.MinValueSize:
+		return o, fmt.Errorf("%w: value size range [%v, %v]", ErrInvalidOptions, o.MinValueSize, o.MaxValueSize)
+	case o.Backend != BackendMap && o.Backend != BackendSplay && o.Backend != BackendMPH:
+		return o, fmt.Errorf("%w: backend %v", ErrInvalidOptions, o.Backend)
+	case o.MPHGamma < 1:
+		return o, fmt.Errorf("%w: mph gamma %v", ErrInvalidOptions, o.MPHGamma)
+	case o.CacheSize > 0 && o.CacheSize/int64(o.CacheShards) < o.cacheEntrySize():
+		return o, fmt.Errorf("%w: cache size %v in %v shards of %v bytes, less than the %v bytes of the largest key and value",
+			ErrInvalidOptions, o.CacheSize, o.CacheShards, o.CacheSize/int64(o.CacheShards), o.cacheEntrySize())
+	case o.NegativeCacheSize > 0 && o.NegativeCacheSize/int64(o.CacheShards) < o.negativeEntrySize():
+		return o, fmt.Errorf("%w: negative cache size %v in %v shards of %v bytes, less than the %v bytes of the largest key",
+			ErrInvalidOptions, o.NegativeCacheSize, o.CacheShards, o.NegativeCacheSize/int64(o.CacheShards), o.negativeEntrySize())
+	}
+	return o, nil
+}
+
+// cacheEntrySize is the size of the largest key and value in the cache.
+func (o Options) cacheEntrySize() int64 {
+	return int64(o.MaxKeySize+o.MaxValueSize) + cache.ENTRY_OVERHEAD
+}
+
+// negativeEntrySize is the size of the largest key in the negative cache,
+// with the deadline kept in front of its empty value when it expires.
+func (o Options) negativeEntrySize() int64 {
+	return int64(o.MaxKeySize+8) + cache.ENTRY_OVERHEAD
+}
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/tabVersion/index-kv/chunk"
+	"github.com/tabVersion/index-kv/splay"
+)
+
+const (
+	// SPLAY_FILE holds the shape of the splay tree of the chunks, saved by
+	// Close so that the chunks splayed near the root stay there when Open
+	// reuses the index.
+	SPLAY_FILE = "index_splay"
+)
+
+// saveSplay writes the chunk ids of the splay tree in its shape, without the
+// chunks.
+func (i *Index) saveSplay() error {
+	data, err := splay.MarshalFunc(i.SplayRoot,
+		func(id uint32) ([]byte, error) { return binary.LittleEndian.AppendUint32(nil, id), nil },
+		func(*chunk.Chunk) ([]byte, error) { return nil, nil })
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(i.opts.IndexDir, SPLAY_FILE)
+	if err = ioutil.WriteFile(path+".tmp", data, 0666); err != nil {
+		return fmt.Errorf("write splay file: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadSplay reshapes the splay tree as saved by saveSplay, and fails when it
+// was saved for another set of chunks.
+func (i *Index) loadSplay() error {
+	data, err := ioutil.ReadFile(filepath.Join(i.opts.IndexDir, SPLAY_FILE))
+	if err != nil {
+		return err
+	}
+	tree := splay.New[uint32, *chunk.Chunk]()
+	err = splay.UnmarshalFunc(tree, data,
+		func(b []byte) (uint32, error) {
+			if len(b) != 4 {
+				return 0, fmt.Errorf("%w: chunk id of %v bytes", splay.ErrCorrupt, len(b))
+			}
+			return binary.LittleEndian.Uint32(b), nil
+		},
+		func([]byte) (*chunk.Chunk, error) { return nil, nil })
+	if err != nil {
+		return err
+	}
+	count := 0
+	for it := splay.NewIterator[uint32, *chunk.Chunk](tree); it.Next(); count++ {
+		n := it.Node()
+		if n.Value = i.findChunk(n.Key()); n.Value == nil {
+			return fmt.Errorf("splay tree of unknown chunk %v", n.Key())
+		}
+	}
+	if count != len(i.chunkIds) {
+		return fmt.Errorf("splay tree of %v chunks, want %v", count, len(i.chunkIds))
+	}
+	i.SplayRoot = tree
+	i.splayChunks = splay.NewConcurrent(tree, i.opts.SplayPeriod)
+	return nil
+}
+
+// initSplay restores the shape of the splay tree of a loaded index. The shape
+// only speeds up lookups, so failures are logged and leave the tree as built.
+func (i *Index) initSplay() {
+	if !i.useSplay {
+		return
+	}
+	if err := i.loadSplay(); err != nil {
+		log.Printf("[index.splay.initSplay] cannot reuse splay tree: %v\n", err)
+	}
+}
@@ -0,0 +1,86 @@
+package index
+
+import (
+	"sync/atomic"
+
+	"github.com/tabVersion/index-kv/cache"
+	"github.com/tabVersion/index-kv/splay"
+)
+
+// counters count the lookups of an Index atomically.
+type counters struct {
+	lookups         int64
+	filterNegatives int64
+	chunkScans      int64
+	candidates      int64
+	dataReads       int64
+	collisions      int64
+	notFound        int64
+}
+
+// Stats is a snapshot of the counters of an Index since it was built or
+// opened. The counters are read one after the other while lookups go on, so
+// they may be off by the lookups in flight.
+type Stats struct {
+	// Cache is the activity of the value cache, zero when it is disabled.
+	Cache cache.Stats
+	// Negative is the activity of the cache of absent keys, zero when it is
+	// disabled.
+	Negative cache.Stats
+	// Lookups counts the lookups that missed the caches, or all of them
+	// without caches.
+	Lookups int64
+	// FilterNegatives counts the lookups of absent keys answered by the Bloom
+	// filter of their chunk.
+	FilterNegatives int64
+	// ChunkScans counts the chunks searched for a key hash, with BackendMap
+	// and BackendSplay.
+	ChunkScans int64
+	// Splays counts the lookups that splayed the chunk they found to the root
+	// of the tree, with BackendSplay.
+	Splays int64
+	// Tree is the shape of the splay tree of the chunks and the work spent on
+	// it, with BackendSplay. Its AvgDepth against the log2 of the chunk count
+	// tells whether splaying pays off over BackendMap.
+	Tree splay.Stats
+	// SlotReads counts the slots read with BackendMPH.
+	SlotReads int64
+	// Candidates counts the records found for the hashes of the looked up
+	// keys.
+	Candidates int64
+	// DataReads counts the records read from the data file.
+	DataReads int64
+	// Collisions counts the records of other keys found for the hash of a
+	// looked up key, ruled out by their fingerprint or by reading their key.
+	Collisions int64
+	// NotFound counts the lookups past the caches of keys that are not in
+	// the data file.
+	NotFound int64
+}
+
+// Stats returns a snapshot of the counters of the index.
+func (i *Index) Stats() Stats {
+	s := Stats{
+		Lookups:         atomic.LoadInt64(&i.counters.lookups),
+		FilterNegatives: atomic.LoadInt64(&i.counters.filterNegatives),
+		ChunkScans:      atomic.LoadInt64(&i.counters.chunkScans),
+		Candidates:      atomic.LoadInt64(&i.counters.candidates),
+		DataReads:       atomic.LoadInt64(&i.counters.dataReads),
+		Collisions:      atomic.LoadInt64(&i.counters.collisions),
+		NotFound:        atomic.LoadInt64(&i.counters.notFound),
+	}
+	if i.cache != nil {
+		s.Cache = i.cache.Stats()
+	}
+	if i.negative != nil {
+		s.Negative = i.negative.Stats()
+	}
+	if i.splayChunks != nil {
+		s.Splays = i.splayChunks.Splays()
+		s.Tree = i.splayChunks.Stats()
+	}
+	if i.mph != nil {
+		s.SlotReads = atomic.LoadInt64(&i.mph.slotReads)
+	}
+	return s
+}
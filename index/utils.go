@@ -7,6 +7,7 @@ import (
 	"os"
 )
 
+// defaults of Options
 const (
 	DATAFILE          = "./alldata"
 	MIN_KEY_SIZE      = 1
@@ -14,19 +15,16 @@ const (
 	MIN_VALUE_SIZE    = 1
 	MAX_VALUE_SIZE    = 1024 // 2^20
 	MAX_ROUTINE_LIMIT = 2000
-	CACHE_SIZE = 100
+	CACHE_SIZE = 64 << 20
+	CACHE_SHARDS = 16
+	SPLAY_PERIOD = 16
+	FILTER_BITS = 2 << 30 // 256M
 	CHUNK_NUM  = 1000
+	BUILD_MEMORY = 1 << 30
+	MPH_GAMMA    = 2.0
 	NUM_KV = 1e3
 )
 
-func Hash(key []byte) uint32 {
-	var hash uint32
-	for _, value := range key {
-		hash = (hash * 31) + uint32(value)
-	}
-	return hash
-}
-
 func GetSizeAndContent(f *os.File) (size uint64, content []byte, err error) {
 	buf := make([]byte, 8)
 	_, err = f.Read(buf)
@@ -0,0 +1,184 @@
+// Package mph implements a minimal perfect hash function over a fixed set of
+// 64-bit keys, following BBHash: every level is a bit array where the keys
+// that did not collide with another key of the level get a bit, and the keys
+// that did are retried on the next level. The index of a key is the rank of
+// its bit among all levels.
+package mph
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	MAX_LEVELS = 64
+	// RANK_WORDS is the number of words between two precomputed ranks.
+	RANK_WORDS = 8
+	MAGIC      = "IKVMPH01"
+)
+
+var (
+	ErrGamma         = errors.New("mph: gamma must be at least 1")
+	ErrTooManyLevels = errors.New("mph: keys still collide after MAX_LEVELS levels, are they unique?")
+	ErrCorrupt       = errors.New("mph: corrupt table")
+)
+
+type level struct {
+	// offset of the first word of the level in Table.bits
+	offset int
+	words  int
+}
+
+// Table maps each of the n keys it was built from to a distinct index in
+// [0, n). Other keys map to an arbitrary index or to none, so callers must
+// check what they find at the index.
+type Table struct {
+	n      int
+	levels []level
+	bits   []uint64
+	ranks  []uint64
+}
+
+// hash mixes key with the level, murmur3's finalizer spreads the bits.
+func hash(key uint64, lvl int) uint64 {
+	x := key + uint64(lvl+1)*0x9e3779b97f4a7c15
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Build returns the table of keys, which must be unique. gamma trades space
+// for build and lookup speed: each level holds gamma bits per remaining key.
+// Build reorders keys.
+func Build(keys []uint64, gamma float64) (*Table, error) {
+	if gamma < 1 || math.IsNaN(gamma) {
+		return nil, ErrGamma
+	}
+	t := &Table{n: len(keys)}
+	remaining := keys
+	for lvl := 0; len(remaining) > 0; lvl++ {
+		if lvl == MAX_LEVELS {
+			return nil, fmt.Errorf("%w: %v keys left", ErrTooManyLevels, len(remaining))
+		}
+		words := int(math.Ceil(gamma*float64(len(remaining))/64)) + 1
+		size := uint64(words) * 64
+		seen := make([]uint64, words)
+		collide := make([]uint64, words)
+		for _, key := range remaining {
+			p := hash(key, lvl) % size
+			if seen[p/64]&(1<<(p%64)) != 0 {
+				collide[p/64] |= 1 << (p % 64)
+			} else {
+				seen[p/64] |= 1 << (p % 64)
+			}
+		}
+		for w := range seen {
+			seen[w] &^= collide[w]
+		}
+		next := remaining[:0]
+		for _, key := range remaining {
+			p := hash(key, lvl) % size
+			if seen[p/64]&(1<<(p%64)) == 0 {
+				next = append(next, key)
+			}
+		}
+		remaining = next
+		t.levels = append(t.levels, level{offset: len(t.bits), words: words})
+		t.bits = append(t.bits, seen...)
+	}
+	t.rank()
+	return t, nil
+}
+
+func (t *Table) rank() {
+	t.ranks = make([]uint64, len(t.bits)/RANK_WORDS+1)
+	var r uint64
+	for w, word := range t.bits {
+		if w%RANK_WORDS == 0 {
+			t.ranks[w/RANK_WORDS] = r
+		}
+		r += uint64(bits.OnesCount64(word))
+	}
+}
+
+// Len returns the number of keys of the table.
+func (t *Table) Len() int {
+	return t.n
+}
+
+// Lookup returns the index of key. ok is false when key is certainly not one
+// of the keys of the table.
+func (t *Table) Lookup(key uint64) (idx int, ok bool) {
+	for lvl, l := range t.levels {
+		p := hash(key, lvl) % (uint64(l.words) * 64)
+		w := l.offset + int(p/64)
+		if t.bits[w]&(1<<(p%64)) == 0 {
+			continue
+		}
+		r := t.ranks[w/RANK_WORDS]
+		for i := w - w%RANK_WORDS; i < w; i++ {
+			r += uint64(bits.OnesCount64(t.bits[i]))
+		}
+		r += uint64(bits.OnesCount64(t.bits[w] & (1<<(p%64) - 1)))
+		return int(r), true
+	}
+	return 0, false
+}
+
+// MarshalBinary encodes the table as MAGIC, the key count, the level count,
+// the words of every level and the bits.
+func (t *Table) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, len(MAGIC)+16+8*len(t.levels)+8*len(t.bits))
+	buf = append(buf, MAGIC...)
+	buf = appendUint64(buf, uint64(t.n))
+	buf = appendUint64(buf, uint64(len(t.levels)))
+	for _, l := range t.levels {
+		buf = appendUint64(buf, uint64(l.words))
+	}
+	for _, word := range t.bits {
+		buf = appendUint64(buf, word)
+	}
+	return buf, nil
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func (t *Table) UnmarshalBinary(data []byte) error {
+	if len(data) < len(MAGIC)+16 || string(data[:len(MAGIC)]) != MAGIC {
+		return fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	data = data[len(MAGIC):]
+	n := binary.LittleEndian.Uint64(data)
+	levels := binary.LittleEndian.Uint64(data[8:])
+	data = data[16:]
+	if levels > MAX_LEVELS || uint64(len(data)) < 8*levels {
+		return fmt.Errorf("%w: %v levels", ErrCorrupt, levels)
+	}
+	t.n = int(n)
+	t.levels = make([]level, levels)
+	words := 0
+	for i := range t.levels {
+		t.levels[i] = level{offset: words, words: int(binary.LittleEndian.Uint64(data[8*i:]))}
+		words += t.levels[i].words
+	}
+	data = data[8*levels:]
+	if len(data) != 8*words {
+		return fmt.Errorf("%w: %v bytes for %v words", ErrCorrupt, len(data), words)
+	}
+	t.bits = make([]uint64, words)
+	for i := range t.bits {
+		t.bits[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	t.rank()
+	return nil
+}
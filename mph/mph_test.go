@@ -0,0 +1,93 @@
+package mph
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func randomKeys(n int) []uint64 {
+	unique := make(map[uint64]struct{}, n)
+	keys := make([]uint64, 0, n)
+	for len(keys) < n {
+		k := rand.Uint64()
+		if _, exist := unique[k]; !exist {
+			unique[k] = struct{}{}
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func TestTable(t *testing.T) {
+	keys := randomKeys(100000)
+	table, err := Build(append([]uint64(nil), keys...), 2)
+	if err != nil {
+		log.Fatalf("build table err: %v", err)
+	}
+	if table.Len() != len(keys) {
+		log.Fatalf("table len: %v, want: %v", table.Len(), len(keys))
+	}
+	seen := make([]bool, len(keys))
+	for _, k := range keys {
+		idx, ok := table.Lookup(k)
+		if !ok || idx < 0 || idx >= len(keys) || seen[idx] {
+			log.Fatalf("lookup key: %v, idx: %v, ok: %v", k, idx, ok)
+		}
+		seen[idx] = true
+	}
+
+	data, _ := table.MarshalBinary()
+	loaded := new(Table)
+	if err = loaded.UnmarshalBinary(data); err != nil {
+		log.Fatalf("unmarshal table err: %v", err)
+	}
+	for _, k := range keys[:1000] {
+		want, _ := table.Lookup(k)
+		if idx, ok := loaded.Lookup(k); !ok || idx != want {
+			log.Fatalf("loaded lookup key: %v, idx: %v, want: %v", k, idx, want)
+		}
+	}
+	if err = loaded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrCorrupt) {
+		log.Fatalf("unmarshal truncated table err: %v", err)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	if _, err := Build([]uint64{1, 2, 2}, 2); !errors.Is(err, ErrTooManyLevels) {
+		log.Fatalf("duplicate keys err: %v, want: %v", err, ErrTooManyLevels)
+	}
+	if _, err := Build([]uint64{1}, 0.5); !errors.Is(err, ErrGamma) {
+		log.Fatalf("small gamma err: %v, want: %v", err, ErrGamma)
+	}
+	table, err := Build(nil, 2)
+	if err != nil || table.Len() != 0 {
+		log.Fatalf("empty table: %v, err: %v", table, err)
+	}
+	if _, ok := table.Lookup(1); ok {
+		log.Fatalf("lookup in empty table succeeded")
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	keys := randomKeys(1000000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Build(append([]uint64(nil), keys...), 2); err != nil {
+			log.Fatalf("build table err: %v", err)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	keys := randomKeys(1000000)
+	table, err := Build(append([]uint64(nil), keys...), 2)
+	if err != nil {
+		log.Fatalf("build table err: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Lookup(keys[i%len(keys)])
+	}
+}
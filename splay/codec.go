@@ -0,0 +1,291 @@
+package splay
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	MAGIC = "IKVSPL01"
+
+	// flags of an encoded node
+	HAS_LEFT  = 1 << 0
+	HAS_RIGHT = 1 << 1
+)
+
+var (
+	ErrCorrupt = errors.New("splay: corrupt tree")
+	// ErrCodec is returned by MarshalBinary and UnmarshalBinary for keys or
+	// values of a type they have no encoding for.
+	ErrCodec = errors.New("splay: no binary encoding")
+)
+
+// MarshalBinary encodes the tree, shape included, as MarshalFunc does with
+// the default encoding of its keys and values: encoding.BinaryMarshaler for
+// the types implementing it, varints for integers, the bits of floats and the
+// bytes of strings and byte slices. A nil interface encodes as no bytes.
+func (t *Tree[K, V]) MarshalBinary() ([]byte, error) {
+	return MarshalFunc(t, encode[K], encode[V])
+}
+
+// UnmarshalBinary replaces the nodes of t with the tree encoded by
+// MarshalBinary, as UnmarshalFunc does with the default encoding of keys and
+// values. Its types must implement encoding.BinaryUnmarshaler through a
+// pointer or be one of the types MarshalBinary encodes, and no bytes decode
+// as the zero value.
+func (t *Tree[K, V]) UnmarshalBinary(data []byte) error {
+	return UnmarshalFunc(t, data, decode[K], decode[V])
+}
+
+// MarshalFunc encodes t as MAGIC and the node count, then every node in
+// preorder as its HAS_LEFT and HAS_RIGHT flags, the size and the encoding by
+// key of its key, and the size and the encoding by value of its value. The
+// shape of the tree is kept, so that decoding it brings back the nodes at the
+// depths splaying left them.
+func MarshalFunc[K, V any](t *Tree[K, V], key func(K) ([]byte, error), value func(V) ([]byte, error)) ([]byte, error) {
+	buf := []byte(MAGIC)
+	buf = binary.AppendUvarint(buf, uint64(t.Len()))
+	var stack []*Node[K, V]
+	if t.root != nil {
+		stack = append(stack, t.root)
+	}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		k, err := key(n.key)
+		if err != nil {
+			return nil, fmt.Errorf("encode key %v: %w", n.key, err)
+		}
+		v, err := value(n.Value)
+		if err != nil {
+			return nil, fmt.Errorf("encode value of key %v: %w", n.key, err)
+		}
+		var flags byte
+		if n.left != nil {
+			flags |= HAS_LEFT
+		}
+		if n.right != nil {
+			flags |= HAS_RIGHT
+			stack = append(stack, n.right)
+		}
+		if n.left != nil {
+			stack = append(stack, n.left)
+		}
+		buf = append(buf, flags)
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	return buf, nil
+}
+
+// UnmarshalFunc replaces the nodes of t with the tree encoded by MarshalFunc,
+// decoding keys with key and values with value. It fails with ErrCorrupt,
+// leaving t as it was, when the keys are not in the order of the comparator
+// of t.
+func UnmarshalFunc[K, V any](t *Tree[K, V], data []byte, key func([]byte) (K, error), value func([]byte) (V, error)) error {
+	if t.compare == nil {
+		return fmt.Errorf("%w: tree without comparator, make it with New or NewFunc", ErrCodec)
+	}
+	if !bytes.HasPrefix(data, []byte(MAGIC)) {
+		return fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	data = data[len(MAGIC):]
+	count, size := binary.Uvarint(data)
+	if size <= 0 {
+		return fmt.Errorf("%w: bad node count", ErrCorrupt)
+	}
+	data = data[size:]
+	field := func() ([]byte, error) {
+		length, size := binary.Uvarint(data)
+		if size <= 0 || uint64(len(data)-size) < length {
+			return nil, fmt.Errorf("%w: truncated node", ErrCorrupt)
+		}
+		b := data[size : size+int(length)]
+		data = data[size+int(length):]
+		return b, nil
+	}
+
+	// slots are the links still to fill, in preorder on top
+	var root *Node[K, V]
+	var slots []**Node[K, V]
+	if count > 0 {
+		slots = append(slots, &root)
+	}
+	nodes := uint64(0)
+	for len(slots) > 0 {
+		if len(data) == 0 || data[0]&^(HAS_LEFT|HAS_RIGHT) != 0 {
+			return fmt.Errorf("%w: bad node %v", ErrCorrupt, nodes)
+		}
+		flags := data[0]
+		data = data[1:]
+		k, err := field()
+		if err != nil {
+			return err
+		}
+		v, err := field()
+		if err != nil {
+			return err
+		}
+		n := new(Node[K, V])
+		if n.key, err = key(k); err != nil {
+			return fmt.Errorf("decode key of node %v: %w", nodes, err)
+		}
+		if n.Value, err = value(v); err != nil {
+			return fmt.Errorf("decode value of key %v: %w", n.key, err)
+		}
+		slot := slots[len(slots)-1]
+		slots = slots[:len(slots)-1]
+		*slot = n
+		nodes++
+		if flags&HAS_RIGHT != 0 {
+			slots = append(slots, &n.right)
+		}
+		if flags&HAS_LEFT != 0 {
+			slots = append(slots, &n.left)
+		}
+	}
+	if nodes != count || len(data) != 0 {
+		return fmt.Errorf("%w: %v nodes of %v, %v bytes left", ErrCorrupt, nodes, count, len(data))
+	}
+
+	decoded := &Tree[K, V]{root: root, compare: t.compare}
+	var prev *Node[K, V]
+	for it := NewIterator[K, V](decoded); it.Next(); prev = it.Node() {
+		if prev != nil && decoded.Ord(prev.key, it.Node().key) >= 0 {
+			return fmt.Errorf("%w: key %v after key %v", ErrCorrupt, it.Node().key, prev.key)
+		}
+	}
+	t.root = root
+	return nil
+}
+
+func encode[T any](v T) ([]byte, error) {
+	switch v := any(v).(type) {
+	case nil:
+		return nil, nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case bool:
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case int:
+		return binary.AppendVarint(nil, int64(v)), nil
+	case int8:
+		return binary.AppendVarint(nil, int64(v)), nil
+	case int16:
+		return binary.AppendVarint(nil, int64(v)), nil
+	case int32:
+		return binary.AppendVarint(nil, int64(v)), nil
+	case int64:
+		return binary.AppendVarint(nil, v), nil
+	case uint:
+		return binary.AppendUvarint(nil, uint64(v)), nil
+	case uint8:
+		return binary.AppendUvarint(nil, uint64(v)), nil
+	case uint16:
+		return binary.AppendUvarint(nil, uint64(v)), nil
+	case uint32:
+		return binary.AppendUvarint(nil, uint64(v)), nil
+	case uint64:
+		return binary.AppendUvarint(nil, v), nil
+	case float32:
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)), nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrCodec, v)
+}
+
+func decode[T any](data []byte) (T, error) {
+	var v T
+	if u, ok := any(&v).(encoding.BinaryUnmarshaler); ok {
+		return v, u.UnmarshalBinary(data)
+	}
+	if len(data) == 0 {
+		return v, nil
+	}
+	varint := func() (int64, error) {
+		x, size := binary.Varint(data)
+		if size != len(data) {
+			return 0, fmt.Errorf("%w: bad varint", ErrCorrupt)
+		}
+		return x, nil
+	}
+	uvarint := func() (uint64, error) {
+		x, size := binary.Uvarint(data)
+		if size != len(data) {
+			return 0, fmt.Errorf("%w: bad varint", ErrCorrupt)
+		}
+		return x, nil
+	}
+	var err error
+	switch p := any(&v).(type) {
+	case *[]byte:
+		*p = append([]byte(nil), data...)
+	case *string:
+		*p = string(data)
+	case *bool:
+		*p = data[0] != 0
+	case *int:
+		var x int64
+		x, err = varint()
+		*p = int(x)
+	case *int8:
+		var x int64
+		x, err = varint()
+		*p = int8(x)
+	case *int16:
+		var x int64
+		x, err = varint()
+		*p = int16(x)
+	case *int32:
+		var x int64
+		x, err = varint()
+		*p = int32(x)
+	case *int64:
+		*p, err = varint()
+	case *uint:
+		var x uint64
+		x, err = uvarint()
+		*p = uint(x)
+	case *uint8:
+		var x uint64
+		x, err = uvarint()
+		*p = uint8(x)
+	case *uint16:
+		var x uint64
+		x, err = uvarint()
+		*p = uint16(x)
+	case *uint32:
+		var x uint64
+		x, err = uvarint()
+		*p = uint32(x)
+	case *uint64:
+		*p, err = uvarint()
+	case *float32:
+		if len(data) != 4 {
+			return v, fmt.Errorf("%w: float32 of %v bytes", ErrCorrupt, len(data))
+		}
+		*p = math.Float32frombits(binary.LittleEndian.Uint32(data))
+	case *float64:
+		if len(data) != 8 {
+			return v, fmt.Errorf("%w: float64 of %v bytes", ErrCorrupt, len(data))
+		}
+		*p = math.Float64frombits(binary.LittleEndian.Uint64(data))
+	default:
+		return v, fmt.Errorf("%w: %T", ErrCodec, v)
+	}
+	return v, err
+}
@@ -0,0 +1,116 @@
+package splay
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// SHALLOW_DEPTH is the depth down to which Concurrent leaves the nodes it
+// finds in place, the root being at depth 1.
+const SHALLOW_DEPTH = 2
+
+// Concurrent guards a tree for lookups from many goroutines. A lookup searches
+// the tree under a read lock without restructuring it, so lookups proceed in
+// parallel, and then only one lookup in period, of a node deeper than
+// SHALLOW_DEPTH, splays it to the root under the write lock. A lookup that
+// finds the write lock held leaves the splaying to the next ones rather than
+// wait. Hot keys are looked up often enough to be splayed up all the same.
+type Concurrent[K, V any] struct {
+	// splays counts the lookups that splayed atomically, and comes first to
+	// stay 64-bit aligned
+	splays int64
+	mutex  sync.RWMutex
+	tree   *Tree[K, V]
+	period int
+}
+
+// NewConcurrent guards tree, splaying one lookup in period, every lookup when
+// period is 1 or less. tree must not be used but through the returned
+// Concurrent from then on.
+func NewConcurrent[K, V any](tree *Tree[K, V], period int) *Concurrent[K, V] {
+	if period < 1 {
+		period = 1
+	}
+	return &Concurrent[K, V]{tree: tree, period: period}
+}
+
+// Get returns the value of key and whether it was found, and may splay its
+// node to the root. Misses never splay.
+func (c *Concurrent[K, V]) Get(key K) (value V, found bool) {
+	c.mutex.RLock()
+	n, depth := c.find(key)
+	if n != nil {
+		value = n.Value
+	}
+	c.mutex.RUnlock()
+	if n == nil {
+		c.tree.searched(depth - 1)
+		return value, false
+	}
+	c.tree.searched(depth)
+	if depth <= SHALLOW_DEPTH || (c.period > 1 && rand.Intn(c.period) != 0) {
+		return value, true
+	}
+	if c.mutex.TryLock() {
+		// the search above is counted already, only count the splay
+		root, _, rotations := splayPath(c.tree.root, func(k K) int { return c.tree.Ord(key, k) })
+		c.tree.root = root
+		c.tree.splayed(rotations)
+		c.mutex.Unlock()
+		atomic.AddInt64(&c.splays, 1)
+	}
+	return value, true
+}
+
+// Find returns the value of key and whether it was found, without
+// restructuring the tree.
+func (c *Concurrent[K, V]) Find(key K) (value V, found bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	n, _ := c.find(key)
+	if n == nil {
+		return value, false
+	}
+	return n.Value, true
+}
+
+// find returns the node of key and its depth, nil if there is none.
+func (c *Concurrent[K, V]) find(key K) (*Node[K, V], int) {
+	depth := 1
+	for n := c.tree.root; n != nil; depth++ {
+		switch c.tree.Ord(key, n.key) {
+		case -1:
+			n = n.left
+		case 0:
+			return n, depth
+		case 1:
+			n = n.right
+		}
+	}
+	return nil, depth
+}
+
+func (c *Concurrent[K, V]) Insert(key K, value V) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return Insert[K, V](c.tree, key, value)
+}
+
+func (c *Concurrent[K, V]) Delete(key K) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return Delete[K, V](c.tree, key)
+}
+
+// Stats returns the stats of the tree.
+func (c *Concurrent[K, V]) Stats() Stats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.tree.Stats()
+}
+
+// Splays returns the number of lookups that splayed the tree.
+func (c *Concurrent[K, V]) Splays() int64 {
+	return atomic.LoadInt64(&c.splays)
+}
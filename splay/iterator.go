@@ -0,0 +1,68 @@
+package splay
+
+// Iterator walks the nodes of a tree in key order without restructuring it.
+// The tree must not change during the walk, which rules out the functions
+// that splay, lookups included.
+type Iterator[K, V any] struct {
+	s Splay[K, V]
+	// stack holds the nodes left to visit whose right subtrees are not
+	// pushed yet, the next node on top
+	stack   []*Node[K, V]
+	to      K
+	bounded bool
+	node    *Node[K, V]
+}
+
+// NewIterator returns an iterator over all the nodes of the tree.
+func NewIterator[K, V any](s Splay[K, V]) *Iterator[K, V] {
+	it := &Iterator[K, V]{s: s}
+	it.pushLeft(s.GetRoot())
+	return it
+}
+
+// NewRangeIterator returns an iterator over the nodes of the keys from from,
+// included, to to, excluded.
+func NewRangeIterator[K, V any](s Splay[K, V], from K, to K) *Iterator[K, V] {
+	it := &Iterator[K, V]{s: s, to: to, bounded: true}
+	n := s.GetRoot()
+	for n != nil {
+		if s.Ord(n.key, from) >= 0 {
+			it.stack = append(it.stack, n)
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return it
+}
+
+// Next moves to the next node and reports whether there is one.
+func (it *Iterator[K, V]) Next() bool {
+	if len(it.stack) == 0 {
+		it.node = nil
+		return false
+	}
+	n := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	if it.bounded && it.s.Ord(n.key, it.to) >= 0 {
+		it.stack = nil
+		it.node = nil
+		return false
+	}
+	it.pushLeft(n.right)
+	it.node = n
+	return true
+}
+
+// Node returns the current node, nil before the first call to Next and after
+// the walk.
+func (it *Iterator[K, V]) Node() *Node[K, V] {
+	return it.node
+}
+
+func (it *Iterator[K, V]) pushLeft(n *Node[K, V]) {
+	for n != nil {
+		it.stack = append(it.stack, n)
+		n = n.left
+	}
+}
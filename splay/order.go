@@ -0,0 +1,155 @@
+package splay
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnordered is returned by Join when the keys of the joined tree are not
+// all greater than the keys of the tree.
+var ErrUnordered = errors.New("splay: joined keys are not all greater")
+
+// Delete removes the node of key from the tree. On a miss it splays the last
+// node on the search path of key to the root instead.
+func Delete[K, V any](s Splay[K, V], key K) error {
+	root := splay(s, key, s.GetRoot())
+	if root == nil || s.Ord(key, root.key) != 0 {
+		s.SetRoot(root)
+		return fmt.Errorf("%w: %v", ErrNotFound, key)
+	}
+	if root.left == nil {
+		s.SetRoot(root.right)
+	} else {
+		// key is greater than all keys of the left subtree, so splaying it
+		// there brings up their greatest key, with no right child to hang the
+		// right subtree from
+		left := splay(s, key, root.left)
+		left.right = root.right
+		s.SetRoot(left)
+	}
+	root.left, root.right = nil, nil
+	return nil
+}
+
+// Min splays the node of the least key to the root and returns it.
+func Min[K, V any](s Splay[K, V]) (*Node[K, V], error) {
+	if s.GetRoot() == nil {
+		return nil, fmt.Errorf("%w: empty tree", ErrNotFound)
+	}
+	s.SetRoot(splayMin(s, s.GetRoot()))
+	return s.GetRoot(), nil
+}
+
+// Max splays the node of the greatest key to the root and returns it.
+func Max[K, V any](s Splay[K, V]) (*Node[K, V], error) {
+	if s.GetRoot() == nil {
+		return nil, fmt.Errorf("%w: empty tree", ErrNotFound)
+	}
+	s.SetRoot(splayMax(s, s.GetRoot()))
+	return s.GetRoot(), nil
+}
+
+// Floor splays the node of the greatest key less than or equal to key to the
+// root and returns it.
+func Floor[K, V any](s Splay[K, V], key K) (*Node[K, V], error) {
+	return closest(s, key, true, true)
+}
+
+// Ceiling splays the node of the least key greater than or equal to key to
+// the root and returns it.
+func Ceiling[K, V any](s Splay[K, V], key K) (*Node[K, V], error) {
+	return closest(s, key, false, true)
+}
+
+// Predecessor splays the node of the greatest key less than key to the root
+// and returns it. key need not be in the tree.
+func Predecessor[K, V any](s Splay[K, V], key K) (*Node[K, V], error) {
+	return closest(s, key, true, false)
+}
+
+// Successor splays the node of the least key greater than key to the root and
+// returns it. key need not be in the tree.
+func Successor[K, V any](s Splay[K, V], key K) (*Node[K, V], error) {
+	return closest(s, key, false, false)
+}
+
+// closest splays the node of the greatest key below key, or of the least key
+// above it, to the root and returns it, key itself included when inclusive.
+func closest[K, V any](s Splay[K, V], key K, below bool, inclusive bool) (*Node[K, V], error) {
+	root := splay(s, key, s.GetRoot())
+	s.SetRoot(root)
+	if root == nil {
+		return nil, fmt.Errorf("%w: empty tree", ErrNotFound)
+	}
+	// the root is now key or its neighbour on either side, and the other
+	// neighbour is the nearest node of the subtree on that side
+	c := s.Ord(root.key, key)
+	if c == 0 && inclusive || below && c < 0 || !below && c > 0 {
+		return root, nil
+	}
+	var n *Node[K, V]
+	if below && root.left != nil {
+		n = splayMax(s, root.left)
+		root.left = n.right
+		n.right = root
+	} else if !below && root.right != nil {
+		n = splayMin(s, root.right)
+		root.right = n.left
+		n.left = root
+	}
+	if n == nil {
+		return nil, fmt.Errorf("%w: no key next to %v", ErrNotFound, key)
+	}
+	s.SetRoot(n)
+	return n, nil
+}
+
+// Split moves the keys greater than or equal to key out of t into the
+// returned tree, which shares the comparator of t.
+func Split[K, V any](t *Tree[K, V], key K) *Tree[K, V] {
+	right := &Tree[K, V]{compare: t.compare}
+	root := splay[K, V](t, key, t.root)
+	if root == nil {
+		return right
+	}
+	if t.Ord(root.key, key) >= 0 {
+		right.root, t.root = root, root.left
+		root.left = nil
+	} else {
+		right.root, t.root = root.right, root
+		root.right = nil
+	}
+	return right
+}
+
+// Join moves the keys of other into t, leaving other empty. The keys of other
+// must all be greater than the keys of t.
+func Join[K, V any](t *Tree[K, V], other *Tree[K, V]) error {
+	if other.root == nil {
+		return nil
+	}
+	if t.root == nil {
+		t.root, other.root = other.root, nil
+		return nil
+	}
+	m, _ := Max[K, V](t)
+	o, _ := Min[K, V](other)
+	if t.Ord(m.key, o.key) >= 0 {
+		return fmt.Errorf("%w: %v is not greater than %v", ErrUnordered, o.key, m.key)
+	}
+	m.right = o
+	other.root = nil
+	return nil
+}
+
+// splayMin splays the node of the least key under root to the top and
+// returns it, with no left child.
+func splayMin[K, V any](s Splay[K, V], root *Node[K, V]) *Node[K, V] {
+	return splayFunc(s, root, func(K) int { return -1 })
+}
+
+// splayMax splays the node of the greatest key under root to the top and
+// returns it, with no right child.
+func splayMax[K, V any](s Splay[K, V], root *Node[K, V]) *Node[K, V] {
+	return splayFunc(s, root, func(K) int { return 1 })
+}
@@ -1,304 +1,221 @@
+// Package splay implements a splay tree, a binary search tree that moves
+// every accessed node to the root, so that hot keys are found near the root.
 package splay
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
-	"github.com/tabVersion/index-kv/chunk"
 	"log"
-	"strconv"
 	"strings"
 )
 
-type Node struct {
-	key    uint32
-	Value  *chunk.Chunk
-	left   *Node
-	right  *Node
-	parent *Node
+var (
+	ErrNotFound  = errors.New("splay: key not found")
+	ErrKeyExists = errors.New("splay: key already exists")
+)
+
+type Node[K, V any] struct {
+	key   K
+	Value V
+	left  *Node[K, V]
+	right *Node[K, V]
 }
 
-type Splay interface {
-	SetRoot(n *Node)
-	GetRoot() *Node
-	Ord(key1, key2 uint32) int
+func (n *Node[K, V]) Key() K {
+	return n.key
 }
 
-type Tree struct {
-	root *Node
+// Splay is a tree the splay functions operate on. Ord returns -1, 0 or 1 when
+// key1 is less than, equal to or greater than key2.
+type Splay[K, V any] interface {
+	SetRoot(n *Node[K, V])
+	GetRoot() *Node[K, V]
+	Ord(key1, key2 K) int
 }
 
-func (t *Tree) GetRoot() *Node {
+// Tree maps keys to values, ordered by its comparator.
+type Tree[K, V any] struct {
+	// counters come first to stay 64-bit aligned for atomic updates
+	counters
+	root    *Node[K, V]
+	compare func(key1, key2 K) int
+}
+
+// New returns an empty tree of keys in their natural order.
+func New[K cmp.Ordered, V any]() *Tree[K, V] {
+	return &Tree[K, V]{compare: cmp.Compare[K]}
+}
+
+// NewFunc returns an empty tree of keys ordered by compare, which returns a
+// negative number, zero or a positive number when key1 is less than, equal to
+// or greater than key2.
+func NewFunc[K, V any](compare func(key1, key2 K) int) *Tree[K, V] {
+	return &Tree[K, V]{compare: compare}
+}
+
+func (t *Tree[K, V]) GetRoot() *Node[K, V] {
 	return t.root
 }
 
-func (t *Tree) SetRoot(root *Node) {
+func (t *Tree[K, V]) SetRoot(root *Node[K, V]) {
 	t.root = root
 }
 
-func (t *Tree) Ord(key1 uint32, key2 uint32) int {
-	if key1 < key2 {
+func (t *Tree[K, V]) Ord(key1 K, key2 K) int {
+	switch c := t.compare(key1, key2); {
+	case c < 0:
+		return -1
+	case c == 0:
 		return 0
-	} else if key1 == key2 {
-		return 1
-	} else {
-		return 2
 	}
+	return 1
 }
 
-func FindNode(s Splay, key uint32, root *Node) *Node {
-	if root == nil {
-		return nil
-	} else {
+// FindNode returns the node of key under root, nil if there is none. Unlike
+// the other lookups it does not restructure the tree.
+func FindNode[K, V any](s Splay[K, V], key K, root *Node[K, V]) *Node[K, V] {
+	for root != nil {
 		switch s.Ord(key, root.key) {
+		case -1:
+			root = root.left
 		case 0:
-			return FindNode(s, key, root.left)
-		case 1:
 			return root
-		case 2:
-			return FindNode(s, key, root.right)
+		case 1:
+			root = root.right
 		}
-		return nil
-	}
-}
-
-func Insert(s Splay, key uint32, value *chunk.Chunk) error {
-	if FindNode(s, key, s.GetRoot()) != nil {
-		log.Fatalf("[splay.splay.Insert] key %v alright exist", key)
 	}
-	n := insertNode(s, key, value, s.GetRoot())
-	splay(s, n)
-	log.Printf("[splay.splay.Insert] insert key: %d", key)
 	return nil
 }
 
-func insertNode(s Splay, key uint32, value *chunk.Chunk, root *Node) *Node {
-	if root == nil {
-		n := new(Node)
-		n.key = key
-		n.Value = value
-		s.SetRoot(n)
-		return s.GetRoot()
-	}
-
-	switch s.Ord(key, root.key) {
-	case 0:
-		if root.left == nil {
-			root.left = new(Node)
-			root.left.key = key
-			root.left.Value = value
-			root.left.parent = root
-			return root.left
-		} else {
-			return insertNode(s, key, value, root.left)
-		}
-	case 2:
-		if root.right == nil {
-			root.right = new(Node)
-			root.right.key = key
-			root.right.Value = value
-			root.right.parent = root
-			return root.right
-		} else {
-			return insertNode(s, key, value, root.right)
+func Insert[K, V any](s Splay[K, V], key K, value V) error {
+	root := splay(s, key, s.GetRoot())
+	s.SetRoot(root)
+	n := &Node[K, V]{key: key, Value: value}
+	if root != nil {
+		switch s.Ord(key, root.key) {
+		case -1:
+			n.left, n.right = root.left, root
+			root.left = nil
+		case 0:
+			return fmt.Errorf("%w: %v", ErrKeyExists, key)
+		case 1:
+			n.left, n.right = root, root.right
+			root.right = nil
 		}
 	}
+	s.SetRoot(n)
+	log.Printf("[splay.splay.Insert] insert key: %v", key)
 	return nil
 }
 
-func splay(s Splay, n *Node) {
-	for n != s.GetRoot() {
-		if n.parent == s.GetRoot() && n.parent.left == n {
-			zigL(s, n)
-		} else if n.parent == s.GetRoot() && n.parent.right == n {
-			zigR(s, n)
-		} else if n.parent.left == n && n.parent.parent.left == n.parent {
-			zigZigL(s, n)
-		} else if n.parent.right == n && n.parent.parent.right == n.parent {
-			zigZigR(s, n)
-		} else if n.parent.right == n && n.parent.parent.left == n.parent {
-			zigZagLR(s, n)
-		} else {
-			zigZagRL(s, n)
-		}
-	}
+// splay splays the node of key under root to the top, or the last node on the
+// search path of key if there is none, and returns it as the new root.
+func splay[K, V any](s Splay[K, V], key K, root *Node[K, V]) *Node[K, V] {
+	return splayFunc(s, root, func(k K) int { return s.Ord(key, k) })
 }
 
-func Access(s Splay, key uint32) *Node {
-	log.Printf("[splay.splay.Access] access key: %d", key)
-	n := FindNode(s, key, s.GetRoot())
-	if n == nil {
-		log.Fatalf("[splay.splay.Access] node not found key: %v\n", key)
+// splayFunc splays the tree under root as splayPath does, and counts the
+// search and the splay when s is a Tree.
+func splayFunc[K, V any](s Splay[K, V], root *Node[K, V], ord func(key K) int) *Node[K, V] {
+	n, depth, rotations := splayPath(root, ord)
+	if t, ok := s.(*Tree[K, V]); ok && n != nil {
+		t.searched(depth)
+		t.splayed(rotations)
 	}
-	splay(s, n)
 	return n
 }
 
-func zigL(s Splay, n *Node) {
-	n.parent.left = n.right
-	if n.right != nil {
-		n.right.parent = n.parent
-	}
-	n.parent.parent = n
-	n.right = n.parent
-	n.parent = nil
-
-	s.SetRoot(n)
-}
-
-func zigR(s Splay, n *Node) {
-	n.parent.right = n.left
-	if n.left != nil {
-		n.left.parent = n.parent
-	}
-	n.parent.parent = n
-	n.left = n.parent
-	n.parent = nil
-
-	s.SetRoot(n)
-}
-
-func zigZigL(s Splay, n *Node) {
-	gg := n.parent.parent.parent
-
-	var isRoot, isLeft bool
-	if gg == nil {
-		isRoot = true
-	} else {
-		isRoot = false
-		isLeft = gg.left == n.parent.parent
-	}
-
-	n.parent.parent.left = n.parent.right
-	if n.parent.right != nil {
-		n.parent.right.parent = n.parent.parent
-	}
-	n.parent.left = n.right
-	if n.right != nil {
-		n.right.parent = n.parent
-	}
-	n.parent.right = n.parent.parent
-	n.parent.parent.parent = n.parent
-	n.right = n.parent
-	n.parent.parent = n
-	n.parent = gg
-
-	if isRoot == true {
-		s.SetRoot(n)
-	} else if isLeft == true {
-		gg.left = n
-	} else {
-		gg.right = n
-	}
-}
-
-func zigZigR(s Splay, n *Node) {
-	gg := n.parent.parent.parent
-
-	var isRoot, isLeft bool
-	if gg == nil {
-		isRoot = true
-	} else {
-		isRoot = false
-		isLeft = gg.left == n.parent.parent
-	}
-
-	n.parent.parent.right = n.parent.left
-	if n.parent.left != nil {
-		n.parent.left.parent = n.parent.parent
-	}
-	n.parent.right = n.left
-	if n.left != nil {
-		n.left.parent = n.parent
-	}
-	n.parent.left = n.parent.parent
-	n.parent.parent.parent = n.parent
-	n.left = n.parent
-	n.parent.parent = n
-	n.parent = gg
-
-	if isRoot == true {
-		s.SetRoot(n)
-	} else if isLeft == true {
-		gg.left = n
-	} else {
-		gg.right = n
-	}
-}
-
-func zigZagLR(s Splay, n *Node) {
-	gg := n.parent.parent.parent
-
-	var isRoot, isLeft bool
-	if gg == nil {
-		isRoot = true
-	} else {
-		isRoot = false
-		isLeft = gg.left == n.parent.parent
-	}
-
-	n.parent.parent.left = n.right
-	if n.right != nil {
-		n.right.parent = n.parent.parent
-	}
-	n.parent.right = n.left
-	if n.left != nil {
-		n.left.parent = n.parent
-	}
-	n.left = n.parent
-	n.right = n.parent.parent
-	n.parent.parent.parent = n
-	n.parent.parent = n
-	n.parent = gg
-
-	if isRoot == true {
-		s.SetRoot(n)
-	} else if isLeft == true {
-		gg.left = n
-	} else {
-		gg.right = n
+// splayPath splays the tree under root top-down, toward the left child of
+// the nodes n where ord(n.key) is -1 and toward the right child where it is
+// 1, and returns the node it stops on as the new root, with its depth before
+// the splay and the rotations it took, each link counted as one. The nodes
+// passed on the way hang off the two trees of the keys left and right of the
+// search path, which become the subtrees of the new root.
+func splayPath[K, V any](root *Node[K, V], ord func(key K) int) (n *Node[K, V], depth int, rotations int) {
+	if root == nil {
+		return nil, 0, 0
+	}
+	// header.right is the tree of the lesser keys and header.left of the
+	// greater keys, last their greatest and first their least node so far
+	var header Node[K, V]
+	last, first := &header, &header
+	n = root
+	depth = 1
+	for {
+		c := ord(n.key)
+		if c < 0 {
+			if n.left == nil {
+				break
+			}
+			if ord(n.left.key) < 0 {
+				// zig-zig: rotate right before linking
+				l := n.left
+				n.left = l.right
+				l.right = n
+				n = l
+				depth++
+				rotations++
+				if n.left == nil {
+					break
+				}
+			}
+			first.left = n
+			first = n
+			n = n.left
+			depth++
+			rotations++
+		} else if c > 0 {
+			if n.right == nil {
+				break
+			}
+			if ord(n.right.key) > 0 {
+				// zig-zig: rotate left before linking
+				r := n.right
+				n.right = r.left
+				r.left = n
+				n = r
+				depth++
+				rotations++
+				if n.right == nil {
+					break
+				}
+			}
+			last.right = n
+			last = n
+			n = n.right
+			depth++
+			rotations++
+		} else {
+			break
+		}
 	}
+	last.right = n.left
+	first.left = n.right
+	n.left = header.right
+	n.right = header.left
+	return n, depth, rotations
 }
 
-func zigZagRL(s Splay, n *Node) {
-	gg := n.parent.parent.parent
-
-	var isRoot, isLeft bool
-	if gg == nil {
-		isRoot = true
-	} else {
-		isRoot = false
-		isLeft = gg.left == n.parent.parent
-	}
-
-	n.parent.parent.right = n.left
-	if n.left != nil {
-		n.left.parent = n.parent.parent
-	}
-	n.parent.left = n.right
-	if n.right != nil {
-		n.right.parent = n.parent
-	}
-	n.right = n.parent
-	n.left = n.parent.parent
-	n.parent.parent.parent = n
-	n.parent.parent = n
-	n.parent = gg
-
-	if isRoot == true {
-		s.SetRoot(n)
-	} else if isLeft == true {
-		gg.left = n
-	} else {
-		gg.right = n
+// Access splays the node of key to the root and returns it. On a miss it
+// splays the last node on the search path of key instead.
+func Access[K, V any](s Splay[K, V], key K) (*Node[K, V], error) {
+	log.Printf("[splay.splay.Access] access key: %v", key)
+	root := splay(s, key, s.GetRoot())
+	s.SetRoot(root)
+	if root == nil || s.Ord(key, root.key) != 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, key)
 	}
+	return root, nil
 }
 
 // ===== printNode tree =====
 
-func PrintTree(s Splay) {
+func PrintTree[K, V any](s Splay[K, V]) {
 	printNode(s.GetRoot(), 0)
 }
 
-func printNode(n *Node, depth int) {
+func printNode[K, V any](n *Node[K, V], depth int) {
 	if n == nil {
 		return
 	}
@@ -307,11 +224,11 @@ func printNode(n *Node, depth int) {
 	printNode(n.right, depth+1)
 }
 
-func Preorder(root *Node, buf *string) {
+func Preorder[K, V any](root *Node[K, V], buf *string) {
 	if root == nil {
 		return
 	}
-	*buf += strconv.Itoa(int(root.key)) + "-"
+	*buf += fmt.Sprint(root.key) + "-"
 	Preorder(root.left, buf)
 	Preorder(root.right, buf)
 }
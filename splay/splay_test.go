@@ -1,13 +1,23 @@
 package splay
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/quick"
 )
 
 func TestSplay(t *testing.T) {
-	splayTree := new(Tree)
+	splayTree := New[uint32, any]()
 	for i := 0; i < 20; i++ {
 		err := Insert(splayTree, rand.Uint32()%10000, nil)
 		if err != nil {
@@ -20,7 +30,7 @@ func TestSplay(t *testing.T) {
 }
 
 func TestTree(t *testing.T) {
-	splayTree := new(Tree)
+	splayTree := New[uint32, any]()
 	var idx []int
 	for i := 0; i < 20; i++ {
 		idx = append(idx, i)
@@ -38,3 +48,760 @@ func TestTree(t *testing.T) {
 		panic("unexpected preorder")
 	}
 }
+
+func TestErrors(t *testing.T) {
+	splayTree := New[uint32, any]()
+	if err := Insert(splayTree, 1, nil); err != nil {
+		log.Fatalf("insert key 1 err: %v", err)
+	}
+	if err := Insert(splayTree, 1, nil); !errors.Is(err, ErrKeyExists) {
+		log.Fatalf("duplicate insert err: %v, want: %v", err, ErrKeyExists)
+	}
+	if _, err := Access(splayTree, 2); !errors.Is(err, ErrNotFound) {
+		log.Fatalf("access missing key err: %v, want: %v", err, ErrNotFound)
+	}
+	if n, err := Access(splayTree, 1); err != nil || n.key != 1 {
+		log.Fatalf("access key 1: %v, err: %v", n, err)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	// string keys in reverse order, with int values
+	tree := NewFunc[string, int](func(key1, key2 string) int { return strings.Compare(key2, key1) })
+	for i, key := range []string{"b", "d", "a", "c"} {
+		if err := Insert(tree, key, i); err != nil {
+			log.Fatalf("insert key %v err: %v", key, err)
+		}
+	}
+	n, err := Access(tree, "d")
+	if err != nil || n.Key() != "d" || n.Value != 1 || tree.GetRoot() != n {
+		log.Fatalf("access key d: %v, err: %v", n, err)
+	}
+	// top-down splaying hangs c off d with b and a below it, where bottom-up
+	// splaying left b under a
+	var buf string
+	Preorder(tree.GetRoot(), &buf)
+	if buf != "d-c-b-a-" {
+		log.Fatalf("preorder of reversed keys: %v", buf)
+	}
+	if tree.Ord("a", "b") != 1 || tree.Ord("b", "a") != -1 || tree.Ord("a", "a") != 0 {
+		log.Fatalln("ord does not follow the comparator")
+	}
+}
+
+// keys returns the keys of the tree in order, checking that they are sorted.
+func keys(tree *Tree[int, int]) []int {
+	var ks []int
+	for it := NewIterator[int, int](tree); it.Next(); {
+		if len(ks) > 0 && ks[len(ks)-1] >= it.Node().key {
+			log.Fatalf("key %v after key %v", it.Node().key, ks[len(ks)-1])
+		}
+		ks = append(ks, it.Node().key)
+	}
+	return ks
+}
+
+func TestOrder(t *testing.T) {
+	tree := New[int, int]()
+	present := map[int]bool{}
+	for i := 0; i < 2000; i++ {
+		key := rand.Intn(200)
+		if rand.Intn(3) == 0 {
+			err := Delete(tree, key)
+			if present[key] != (err == nil) || (err != nil && !errors.Is(err, ErrNotFound)) {
+				log.Fatalf("delete key %v: %v, present: %v", key, err, present[key])
+			}
+			delete(present, key)
+		} else if err := Insert(tree, key, -key); present[key] != (err != nil) {
+			log.Fatalf("insert key %v: %v, present: %v", key, err, present[key])
+		} else {
+			present[key] = true
+		}
+
+		var sorted []int
+		for k := range present {
+			sorted = append(sorted, k)
+		}
+		sort.Ints(sorted)
+		if got := keys(tree); fmt.Sprint(got) != fmt.Sprint(sorted) {
+			log.Fatalf("keys: %v, want: %v", got, sorted)
+		}
+
+		// the reference answers from the sorted keys, -1 for none
+		key = rand.Intn(220) - 10
+		at := sort.SearchInts(sorted, key)
+		exact := at < len(sorted) && sorted[at] == key
+		want := map[string]int{"floor": -1, "ceiling": -1, "predecessor": -1, "successor": -1, "min": -1, "max": -1}
+		if len(sorted) > 0 {
+			want["min"], want["max"] = sorted[0], sorted[len(sorted)-1]
+		}
+		if exact {
+			want["floor"], want["ceiling"] = key, key
+		}
+		if at > 0 {
+			want["predecessor"] = sorted[at-1]
+			if !exact {
+				want["floor"] = sorted[at-1]
+			}
+		}
+		if at < len(sorted) {
+			if !exact {
+				want["ceiling"] = sorted[at]
+			}
+			if exact && at+1 < len(sorted) {
+				want["successor"] = sorted[at+1]
+			} else if !exact {
+				want["successor"] = sorted[at]
+			}
+		}
+		got := map[string]func() (*Node[int, int], error){
+			"floor":       func() (*Node[int, int], error) { return Floor(tree, key) },
+			"ceiling":     func() (*Node[int, int], error) { return Ceiling(tree, key) },
+			"predecessor": func() (*Node[int, int], error) { return Predecessor(tree, key) },
+			"successor":   func() (*Node[int, int], error) { return Successor(tree, key) },
+			"min":         func() (*Node[int, int], error) { return Min(tree) },
+			"max":         func() (*Node[int, int], error) { return Max(tree) },
+		}
+		for op, f := range got {
+			n, err := f()
+			switch {
+			case want[op] == -1 && !errors.Is(err, ErrNotFound):
+				log.Fatalf("%v of %v in %v: %v, err: %v, want: not found", op, key, sorted, n, err)
+			case want[op] != -1 && (err != nil || n.key != want[op] || n.Value != -n.key || tree.GetRoot() != n):
+				log.Fatalf("%v of %v in %v: %v, err: %v, want: %v at the root", op, key, sorted, n, err, want[op])
+			}
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	tree := New[int, int]()
+	for _, key := range rand.Perm(100) {
+		if err := Insert(tree, 2*key, key); err != nil {
+			log.Fatalf("insert key %v err: %v", 2*key, err)
+		}
+	}
+	walk := func(it *Iterator[int, int]) []int {
+		var ks []int
+		for it.Next() {
+			ks = append(ks, it.Node().Key())
+		}
+		if it.Node() != nil {
+			log.Fatalf("node after the walk: %v", it.Node())
+		}
+		return ks
+	}
+	if got := walk(NewIterator[int, int](tree)); fmt.Sprint(got) != fmt.Sprint(keys(tree)) || len(got) != 100 {
+		log.Fatalf("walk: %v", got)
+	}
+	for _, r := range [][3]int{{10, 20, 5}, {11, 20, 4}, {-5, 3, 2}, {190, 500, 5}, {50, 50, 0}, {60, 40, 0}, {300, 400, 0}} {
+		got := walk(NewRangeIterator[int, int](tree, r[0], r[1]))
+		if len(got) != r[2] {
+			log.Fatalf("walk from %v to %v: %v, want %v keys", r[0], r[1], got, r[2])
+		}
+		for _, key := range got {
+			if key < r[0] || key >= r[1] {
+				log.Fatalf("walk from %v to %v: %v", r[0], r[1], got)
+			}
+		}
+	}
+	if New[int, int]().GetRoot() != nil || NewIterator[int, int](New[int, int]()).Next() {
+		log.Fatalln("walk of an empty tree")
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	tree := New[int, int]()
+	for _, key := range rand.Perm(50) {
+		if err := Insert(tree, key, key); err != nil {
+			log.Fatalf("insert key %v err: %v", key, err)
+		}
+	}
+	right := Split(tree, 20)
+	if left, r := keys(tree), keys(right); len(left) != 20 || left[19] != 19 || len(r) != 30 || r[0] != 20 {
+		log.Fatalf("split at 20: %v and %v", left, r)
+	}
+	if err := Join(right, tree); !errors.Is(err, ErrUnordered) {
+		log.Fatalf("join of lesser keys err: %v, want: %v", err, ErrUnordered)
+	}
+	if err := Join(tree, right); err != nil {
+		log.Fatalf("join err: %v", err)
+	}
+	if ks := keys(tree); len(ks) != 50 || right.GetRoot() != nil {
+		log.Fatalf("join: %v, left over: %v", ks, keys(right))
+	}
+	if r := Split(tree, 100); r.GetRoot() != nil || len(keys(tree)) != 50 {
+		log.Fatalf("split past the keys: %v", keys(r))
+	}
+	if r := Split(tree, -1); tree.GetRoot() != nil || len(keys(r)) != 50 {
+		log.Fatalf("split before the keys: %v", keys(tree))
+	}
+}
+
+// op is a random operation for TestQuick, on a small key space so that hits
+// and misses both happen.
+type op struct {
+	Kind uint8
+	Key  uint8
+}
+
+// neighbours returns the greatest key of sorted below key and the least one
+// above it, ok being false when there is none on that side.
+func neighbours(sorted []int, key int) (below int, hasBelow bool, above int, hasAbove bool) {
+	i := sort.SearchInts(sorted, key)
+	if i > 0 {
+		below, hasBelow = sorted[i-1], true
+	}
+	if i < len(sorted) && sorted[i] == key {
+		i++
+	}
+	if i < len(sorted) {
+		above, hasAbove = sorted[i], true
+	}
+	return below, hasBelow, above, hasAbove
+}
+
+func TestQuick(t *testing.T) {
+	// after any sequence of operations the tree must hold the keys and values
+	// of a map model in order, answer every lookup as the sorted keys of the
+	// model do, and leave the node found, or on a miss the closest node of
+	// the missing key, at the root
+	property := func(ops []op) bool {
+		tree := New[int, int]()
+		model := map[int]int{}
+		for n, o := range ops {
+			key := int(o.Key % 64)
+			sorted := make([]int, 0, len(model))
+			for k := range model {
+				sorted = append(sorted, k)
+			}
+			sort.Ints(sorted)
+			_, present := model[key]
+			below, hasBelow, above, hasAbove := neighbours(sorted, key)
+			// found checks a lookup that should return the node of want when ok,
+			// and fail with ErrNotFound otherwise
+			found := func(node *Node[int, int], err error, want int, ok bool) bool {
+				if !ok {
+					return node == nil && errors.Is(err, ErrNotFound)
+				}
+				return err == nil && node.key == want && node.Value == model[want] && tree.GetRoot() == node
+			}
+			lookup := true
+			switch o.Kind % 10 {
+			case 0:
+				if err := Insert(tree, key, n); (err == nil) == present || tree.GetRoot().key != key {
+					return false
+				}
+				if !present {
+					model[key] = n
+				}
+				lookup = false
+			case 1:
+				if err := Delete(tree, key); (err == nil) != present {
+					return false
+				}
+				delete(model, key)
+				lookup = false
+			case 2:
+				if node, err := Access(tree, key); !found(node, err, key, present) {
+					return false
+				}
+			case 3:
+				root := tree.GetRoot()
+				node := FindNode[int, int](tree, key, root)
+				if (node != nil) != present || present && node.Value != model[key] || tree.GetRoot() != root {
+					return false
+				}
+				lookup = false
+			case 4:
+				node, err := Min[int, int](tree)
+				if len(sorted) > 0 && !found(node, err, sorted[0], true) || len(sorted) == 0 && !found(node, err, 0, false) {
+					return false
+				}
+				lookup = false
+			case 5:
+				node, err := Max[int, int](tree)
+				if len(sorted) > 0 && !found(node, err, sorted[len(sorted)-1], true) || len(sorted) == 0 && !found(node, err, 0, false) {
+					return false
+				}
+				lookup = false
+			case 6:
+				want, ok := below, hasBelow
+				if present {
+					want, ok = key, true
+				}
+				if node, err := Floor(tree, key); !found(node, err, want, ok) {
+					return false
+				}
+			case 7:
+				want, ok := above, hasAbove
+				if present {
+					want, ok = key, true
+				}
+				if node, err := Ceiling(tree, key); !found(node, err, want, ok) {
+					return false
+				}
+			case 8:
+				if node, err := Predecessor(tree, key); !found(node, err, below, hasBelow) {
+					return false
+				}
+			case 9:
+				if node, err := Successor(tree, key); !found(node, err, above, hasAbove) {
+					return false
+				}
+			}
+			// every lookup of key leaves key or a neighbour of it at the root
+			if root := tree.GetRoot(); lookup && root != nil {
+				if root.key != key && !(hasBelow && root.key == below) && !(hasAbove && root.key == above) {
+					return false
+				}
+			}
+			// the tree holds the keys and values of the model in order
+			sorted = sorted[:0]
+			for k := range model {
+				sorted = append(sorted, k)
+			}
+			sort.Ints(sorted)
+			got := 0
+			for it := NewIterator[int, int](tree); it.Next(); got++ {
+				if got >= len(sorted) || it.Node().key != sorted[got] || it.Node().Value != model[sorted[got]] {
+					return false
+				}
+			}
+			if got != len(sorted) {
+				return false
+			}
+		}
+		return true
+	}
+	log.SetOutput(io.Discard)
+	err := quick.Check(property, &quick.Config{MaxCount: 500})
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatalf("tree differs from the model: %v", err)
+	}
+}
+
+// recursive is the bottom-up splay tree with parent pointers that Tree
+// replaced, kept as a reference for Insert, FindNode and Access.
+type recursive struct {
+	root *recursiveNode
+}
+
+type recursiveNode struct {
+	key, value          int
+	left, right, parent *recursiveNode
+}
+
+func (t *recursive) find(key int, root *recursiveNode) *recursiveNode {
+	if root == nil || root.key == key {
+		return root
+	}
+	if key < root.key {
+		return t.find(key, root.left)
+	}
+	return t.find(key, root.right)
+}
+
+func (t *recursive) insert(key, value int) bool {
+	if t.find(key, t.root) != nil {
+		return false
+	}
+	n := &recursiveNode{key: key, value: value}
+	if t.root == nil {
+		t.root = n
+	} else {
+		t.insertNode(n, t.root)
+	}
+	t.splay(n)
+	return true
+}
+
+func (t *recursive) insertNode(n, root *recursiveNode) {
+	if n.key < root.key {
+		if root.left == nil {
+			root.left, n.parent = n, root
+			return
+		}
+		t.insertNode(n, root.left)
+		return
+	}
+	if root.right == nil {
+		root.right, n.parent = n, root
+		return
+	}
+	t.insertNode(n, root.right)
+}
+
+func (t *recursive) access(key int) *recursiveNode {
+	n := t.find(key, t.root)
+	if n != nil {
+		t.splay(n)
+	}
+	return n
+}
+
+// rotate moves n above its parent.
+func (t *recursive) rotate(n *recursiveNode) {
+	p, g := n.parent, n.parent.parent
+	if p.left == n {
+		p.left = n.right
+		if n.right != nil {
+			n.right.parent = p
+		}
+		n.right = p
+	} else {
+		p.right = n.left
+		if n.left != nil {
+			n.left.parent = p
+		}
+		n.left = p
+	}
+	p.parent, n.parent = n, g
+	if g == nil {
+		t.root = n
+	} else if g.left == p {
+		g.left = n
+	} else {
+		g.right = n
+	}
+}
+
+// splay brings n to the root by zig, zig-zig and zig-zag steps.
+func (t *recursive) splay(n *recursiveNode) {
+	for n.parent != nil {
+		if p, g := n.parent, n.parent.parent; g != nil {
+			if (g.left == p) == (p.left == n) {
+				t.rotate(p)
+			} else {
+				t.rotate(n)
+			}
+		}
+		t.rotate(n)
+	}
+}
+
+func (t *recursive) inorder(root *recursiveNode, visit func(*recursiveNode)) {
+	if root != nil {
+		t.inorder(root.left, visit)
+		visit(root)
+		t.inorder(root.right, visit)
+	}
+}
+
+func TestQuick_Recursive(t *testing.T) {
+	// the top-down tree must answer Insert, FindNode and Access as the former
+	// bottom-up one did, bring the same node to the root and hold the same
+	// keys and values in order, although the shapes of the trees differ
+	property := func(ops []op) bool {
+		tree := New[int, int]()
+		ref := &recursive{}
+		for n, o := range ops {
+			key := int(o.Key % 64)
+			switch o.Kind % 3 {
+			case 0:
+				// the former tree stopped the process on an existing key
+				err := Insert(tree, key, n)
+				if inserted := ref.insert(key, n); (err == nil) != inserted || inserted && tree.GetRoot().key != ref.root.key {
+					return false
+				}
+			case 1:
+				node, want := FindNode[int, int](tree, key, tree.GetRoot()), ref.find(key, ref.root)
+				if (node != nil) != (want != nil) || node != nil && node.Value != want.value {
+					return false
+				}
+			case 2:
+				node, err := Access(tree, key)
+				want := ref.access(key)
+				if (err == nil) != (want != nil) || want != nil && (node.Value != want.value || tree.GetRoot().key != ref.root.key) {
+					return false
+				}
+			}
+			var got, wanted []int
+			for it := NewIterator[int, int](tree); it.Next(); {
+				got = append(got, it.Node().key, it.Node().Value)
+			}
+			ref.inorder(ref.root, func(n *recursiveNode) { wanted = append(wanted, n.key, n.value) })
+			if fmt.Sprint(got) != fmt.Sprint(wanted) {
+				return false
+			}
+		}
+		return true
+	}
+	log.SetOutput(io.Discard)
+	err := quick.Check(property, &quick.Config{MaxCount: 500})
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatalf("tree differs from the recursive one: %v", err)
+	}
+}
+
+// depth returns the depth of the node of key, with the root at depth 1.
+func depth(tree *Tree[int, int], key int) int {
+	d := 1
+	for n := tree.GetRoot(); n.key != key; d++ {
+		if key < n.key {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return d
+}
+
+func TestDegenerate(t *testing.T) {
+	// sequential inserts leave a path as deep as the tree, which splaying
+	// walks without recursion and roughly halves
+	const size = 100000
+	tree := New[int, int]()
+	log.SetOutput(io.Discard)
+	for key := 0; key < size; key++ {
+		_ = Insert(tree, key, key)
+	}
+	log.SetOutput(os.Stderr)
+	if d := depth(tree, 0); d != size {
+		log.Fatalf("depth of key 0: %v, want: %v", d, size)
+	}
+	if _, err := Access(tree, 0); err != nil {
+		log.Fatalf("access key 0 err: %v", err)
+	}
+	if d := depth(tree, 1); d > size/2+2 {
+		log.Fatalf("depth of key 1 after splaying key 0: %v", d)
+	}
+	if _, err := Access(tree, -1); !errors.Is(err, ErrNotFound) || tree.GetRoot().key != 0 {
+		log.Fatalf("access key -1 err: %v, root: %v", err, tree.GetRoot().key)
+	}
+	if len(keys(tree)) != size {
+		log.Fatalf("keys after splaying: %v", len(keys(tree)))
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	log.SetOutput(io.Discard)
+	tree := New[int, int]()
+	for key := 0; key < 1000; key++ {
+		_ = Insert(tree, key, -key)
+	}
+	log.SetOutput(os.Stderr)
+	c := NewConcurrent(tree, 4)
+
+	// lookups of a hot key from many goroutines, while another goroutine
+	// inserts keys, splay it up
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				if value, found := c.Get(7); !found || value != -7 {
+					log.Fatalf("get key 7: %v, found: %v", value, found)
+				}
+				if _, found := c.Get(-1); found {
+					log.Fatalln("found key -1")
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.SetOutput(io.Discard)
+		for key := 1000; key < 1100; key++ {
+			if err := c.Insert(key, -key); err != nil {
+				log.Fatalf("insert key %v err: %v", key, err)
+			}
+		}
+	}()
+	wg.Wait()
+	log.SetOutput(os.Stderr)
+
+	if c.Splays() == 0 || c.Splays() > 8*1000 {
+		log.Fatalf("splays: %v", c.Splays())
+	}
+	// sequential inserts after the last splay push the hot key down the
+	// left spine, a few more lookups bring it up
+	for n := 0; n < 100; n++ {
+		c.Get(7)
+	}
+	if _, depth := c.find(7); depth > SHALLOW_DEPTH {
+		log.Fatalf("depth of the hot key: %v", depth)
+	}
+	if len(keys(tree)) != 1100 {
+		log.Fatalf("keys after the lookups: %v", len(keys(tree)))
+	}
+
+	// a lookup of a shallow node or with Find never splays
+	splays := c.Splays()
+	if _, found := c.Find(500); !found || c.Splays() != splays {
+		log.Fatalf("find key 500: %v, splays: %v", found, c.Splays())
+	}
+	every := NewConcurrent(tree, 0)
+	for _, key := range rand.Perm(100) {
+		_, before := every.find(key)
+		splays := every.Splays()
+		every.Get(key)
+		if before > SHALLOW_DEPTH && (tree.GetRoot().key != key || every.Splays() != splays+1) {
+			log.Fatalf("root after get key %v from depth %v: %v", key, before, tree.GetRoot().key)
+		}
+		if before <= SHALLOW_DEPTH && every.Splays() != splays {
+			log.Fatalf("get key %v from depth %v splayed", key, before)
+		}
+	}
+}
+
+func BenchmarkGet_Parallel(b *testing.B) {
+	// skewed lookups of 1000 keys, as of the chunks of an index
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.5, 1, 999)
+	lookups := make([]int, 100000)
+	for n := range lookups {
+		lookups[n] = int(zipf.Uint64())
+	}
+	newTree := func() *Tree[int, int] {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		tree := New[int, int]()
+		for _, key := range rand.Perm(1000) {
+			_ = Insert(tree, key, key)
+		}
+		return tree
+	}
+	run := func(b *testing.B, get func(key int)) {
+		var start int64
+		b.SetParallelism(16)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			n := int(atomic.AddInt64(&start, 7919))
+			for ; pb.Next(); n++ {
+				get(lookups[n%len(lookups)])
+			}
+		})
+	}
+	b.Run("mutex", func(b *testing.B) {
+		// every lookup splays under one lock
+		tree := newTree()
+		var mutex sync.Mutex
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		run(b, func(key int) {
+			mutex.Lock()
+			_, _ = Access(tree, key)
+			mutex.Unlock()
+		})
+	})
+	for _, period := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("period-%v", period), func(b *testing.B) {
+			c := NewConcurrent(newTree(), period)
+			run(b, func(key int) { c.Get(key) })
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	log.SetOutput(io.Discard)
+	tree := New[int, string]()
+	for _, key := range rand.Perm(200) {
+		_ = Insert(tree, key-100, strings.Repeat("v", key%7))
+	}
+	for n := 0; n < 100; n++ {
+		_, _ = Access(tree, rand.Intn(10))
+	}
+	log.SetOutput(os.Stderr)
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		log.Fatalf("marshal err: %v", err)
+	}
+	loaded := New[int, string]()
+	if err = loaded.UnmarshalBinary(data); err != nil {
+		log.Fatalf("unmarshal err: %v", err)
+	}
+	var want, got string
+	Preorder(tree.GetRoot(), &want)
+	Preorder(loaded.GetRoot(), &got)
+	if got != want {
+		log.Fatalf("preorder after unmarshal: %v, want: %v", got, want)
+	}
+	for it := NewIterator[int, string](tree); it.Next(); {
+		if n := FindNode[int, string](loaded, it.Node().key, loaded.GetRoot()); n == nil || n.Value != it.Node().Value {
+			log.Fatalf("value of key %v after unmarshal: %v, want: %v", it.Node().key, n, it.Node().Value)
+		}
+	}
+
+	// the keys of nil values and custom encodings
+	shape := New[uint32, any]()
+	for key := uint32(0); key < 10; key++ {
+		_ = Insert(shape, key, nil)
+	}
+	data, err = MarshalFunc(shape,
+		func(key uint32) ([]byte, error) { return []byte{byte(key)}, nil },
+		func(any) ([]byte, error) { return nil, nil })
+	if err != nil {
+		log.Fatalf("marshal with funcs err: %v", err)
+	}
+	ids := New[uint32, any]()
+	err = UnmarshalFunc(ids, data,
+		func(b []byte) (uint32, error) { return uint32(b[0]), nil },
+		func([]byte) (any, error) { return nil, nil })
+	if err != nil || ids.Height() != 10 || ids.GetRoot().key != 9 {
+		log.Fatalf("unmarshal with funcs: %v, err: %v", ids.Stats(), err)
+	}
+	if data, err = shape.MarshalBinary(); err != nil {
+		log.Fatalf("marshal nil values err: %v", err)
+	}
+	if err = New[uint32, any]().UnmarshalBinary(data); err != nil {
+		log.Fatalf("unmarshal nil values err: %v", err)
+	}
+
+	for name, bad := range map[string][]byte{
+		"no header": []byte("IKVXXX01"),
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte(nil), data...), 0),
+	} {
+		if err = New[uint32, any]().UnmarshalBinary(bad); !errors.Is(err, ErrCorrupt) {
+			log.Fatalf("unmarshal %v err: %v, want: %v", name, err, ErrCorrupt)
+		}
+	}
+	reversed := NewFunc[uint32, any](func(key1, key2 uint32) int { return cmp.Compare(key2, key1) })
+	if err = reversed.UnmarshalBinary(data); !errors.Is(err, ErrCorrupt) || reversed.GetRoot() != nil {
+		log.Fatalf("unmarshal into another order err: %v, want: %v", err, ErrCorrupt)
+	}
+	if err = new(Tree[uint32, any]).UnmarshalBinary(data); !errors.Is(err, ErrCodec) {
+		log.Fatalf("unmarshal without comparator err: %v, want: %v", err, ErrCodec)
+	}
+	structs := New[int, struct{ x int }]()
+	_ = Insert(structs, 1, struct{ x int }{1})
+	if _, err = structs.MarshalBinary(); !errors.Is(err, ErrCodec) {
+		log.Fatalf("marshal struct values err: %v, want: %v", err, ErrCodec)
+	}
+}
+
+func TestStats(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	tree := New[int, int]()
+	if s := tree.Stats(); s != (Stats{}) {
+		log.Fatalf("stats of an empty tree: %+v", s)
+	}
+	// sequential inserts search one node each and leave a path
+	for key := 0; key < 100; key++ {
+		_ = Insert(tree, key, key)
+	}
+	s := tree.Stats()
+	if s.Len != 100 || s.Height != 100 || s.Searches != 99 || s.Splays != 99 || s.AvgDepth != 1 || s.Rotations != 0 {
+		log.Fatalf("stats after sequential inserts: %+v", s)
+	}
+	// the first lookup walks the path, the next ones find the key at the root
+	for n := 0; n < 10; n++ {
+		_, _ = Access(tree, 0)
+	}
+	s = tree.Stats()
+	if s.Searches != 109 || s.Rotations != 99 || s.Height >= 100 || s.AvgDepth != float64(99+100+9)/109 {
+		log.Fatalf("stats after lookups: %+v", s)
+	}
+
+	c := NewConcurrent(tree, 1)
+	c.Get(0)
+	c.Get(-1)
+	if cs := c.Stats(); cs.Searches != 111 || cs.Splays != s.Splays || cs.Len != 100 {
+		log.Fatalf("stats after concurrent lookups: %+v", cs)
+	}
+}
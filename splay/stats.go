@@ -0,0 +1,92 @@
+package splay
+
+import "sync/atomic"
+
+// counters count the work on a tree atomically, so that the lookups of
+// Concurrent count their searches under the read lock.
+type counters struct {
+	searches  int64
+	depths    int64
+	splays    int64
+	rotations int64
+}
+
+func (c *counters) searched(depth int) {
+	atomic.AddInt64(&c.searches, 1)
+	atomic.AddInt64(&c.depths, int64(depth))
+}
+
+func (c *counters) splayed(rotations int) {
+	atomic.AddInt64(&c.splays, 1)
+	atomic.AddInt64(&c.rotations, int64(rotations))
+}
+
+// Stats is a snapshot of the shape of a tree and of the work spent on it, to
+// tell whether splaying pays off: a skewed workload keeps AvgDepth well below
+// the depth of a balanced tree of Len nodes, about log2(Len).
+type Stats struct {
+	// Len is the number of nodes.
+	Len int
+	// Height is the number of nodes on the longest path from the root.
+	Height int
+	// Searches counts the walks down the tree of lookups, inserts and
+	// deletes, hits and misses alike.
+	Searches int64
+	// AvgDepth is the average depth of the last node of a search, the root
+	// being at depth 1.
+	AvgDepth float64
+	// Splays counts the searches that splayed.
+	Splays int64
+	// Rotations counts the rotations of the splays, each link of the
+	// top-down splaying counted as one.
+	Rotations int64
+}
+
+// Len returns the number of nodes of the tree. It walks the whole tree.
+func (t *Tree[K, V]) Len() int {
+	n := 0
+	for it := NewIterator[K, V](t); it.Next(); {
+		n++
+	}
+	return n
+}
+
+// Height returns the number of nodes on the longest path from the root, 0
+// for an empty tree. It walks the whole tree.
+func (t *Tree[K, V]) Height() int {
+	height := 0
+	var level []*Node[K, V]
+	if t.root != nil {
+		level = append(level, t.root)
+	}
+	for len(level) > 0 {
+		height++
+		var next []*Node[K, V]
+		for _, n := range level {
+			if n.left != nil {
+				next = append(next, n.left)
+			}
+			if n.right != nil {
+				next = append(next, n.right)
+			}
+		}
+		level = next
+	}
+	return height
+}
+
+// Stats returns the shape of the tree and the counters of the work spent on
+// it since it was created.
+func (t *Tree[K, V]) Stats() Stats {
+	s := Stats{
+		Len:       t.Len(),
+		Height:    t.Height(),
+		Searches:  atomic.LoadInt64(&t.searches),
+		Splays:    atomic.LoadInt64(&t.splays),
+		Rotations: atomic.LoadInt64(&t.rotations),
+	}
+	if s.Searches > 0 {
+		s.AvgDepth = float64(atomic.LoadInt64(&t.depths)) / float64(s.Searches)
+	}
+	return s
+}